@@ -18,8 +18,13 @@
 package agent
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"io"
 	"log"
+	"net/http"
+	"time"
 )
 
 type Agent interface{}
@@ -34,43 +39,162 @@ func (ampf AmplifierFunc) ThreadRoutine(ID int, ctx context.Context, endpoint st
 	return ampf(ID, ctx, endpoint, repeat, trace, threadDown)
 }
 
+type AmplifierOption func(gamp *GeneralAmplifier)
+
+// WithBatch re-chunks captured traces into payloads of size traces, spans or bytes,
+// an empty unit keeps the payload as captured.
+func WithBatch(by string, size int) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.batchBy = by
+		gamp.batchSize = size
+	}
+}
+
+// WithCompression compresses replayed request bodies with gzip, deflate or zstd.
+func WithCompression(encoding string) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.compression = encoding
+	}
+}
+
+// WithTLS enables https towards collector, see NewClientTLSConfig.
+func WithTLS(tlsConf *tls.Config) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.tlsConfig = tlsConf
+	}
+}
+
+// WithHeaders sets headers on every replayed request, they override headers captured from tracer.
+func WithHeaders(header http.Header) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.header = header
+	}
+}
+
+// WithArchive saves every captured request into archive for later replay.
+func WithArchive(archive *ArchiveWriter) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.archive = archive
+	}
+}
+
+// WithStats counts traffic sent by amplifier threads into stats.
+func WithStats(stats *Stats) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.stats = stats
+	}
+}
+
+// WithStartAt holds threads until t once traces are captured, so that amplifiers on
+// several hosts start sending at the same time. Threads start at once if t has passed.
+func WithStartAt(t time.Time) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.startAt = t
+	}
+}
+
+// WithWarmup makes every thread send warm-up requests before measured ones, so that
+// connection setup and collector warm-up don't distort short runs. Warm-up ends after
+// requests or duration, whichever comes first, zero disables either limit. Warm-up
+// requests are counted in Stats.Warmup only.
+func WithWarmup(requests int, duration time.Duration) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.warmupRequests = requests
+		gamp.warmupDuration = duration
+	}
+}
+
 type GeneralAmplifier struct {
 	name            string
 	threads, repeat int
+	batchBy         string
+	batchSize       int
+	compression     string
+	tlsConfig       *tls.Config
+	conn            *ConnOptions
+	retry           *RetryOptions
+	queueSize       int
+	queueInterval   time.Duration
+	header          http.Header
+	archive         *ArchiveWriter
+	stats           *Stats
+	startAt         time.Time
+	warmupRequests  int
+	warmupDuration  time.Duration
+	capture         *Capture
+	captureTimeout  time.Duration
+	captureProceed  bool
 	threadRoutine   AmplifierFunc
 	close           chan struct{}
+	// closed when StartThreads returns
+	exit chan struct{}
+	// set once captured traces are handed over
+	fired int32
 }
 
+// StartThreads launches amplifier threads once traces arrive from in. Cancelling ctx or
+// closing the amplifier stops threads from sending more, finish is closed after requests
+// in flight are drained, or right away if no thread has started.
 func (gamp *GeneralAmplifier) StartThreads(ctx context.Context, endpoint string, in chan any) (finish chan struct{}, err error) {
 	if err = ctx.Err(); err != nil {
 		return
 	}
 
 	finish = make(chan struct{})
+	ctx, cancel := context.WithCancel(ctx)
 	go func() {
+		defer close(gamp.exit)
+		defer close(finish)
+		defer cancel()
+
 		var (
-			threadDown = make(chan int)
-			finished   = 0
+			threadDown        = make(chan int)
+			started, finished int
+			done              = ctx.Done()
+			closing           = gamp.close
 		)
 		for {
 			select {
-			case <-ctx.Done():
-				if err := ctx.Err(); err != nil {
-					log.Println(err.Error())
-				} else {
-					log.Println("GeneralAmplifier context done")
-				}
-			case <-gamp.close:
+			case <-closing:
 				log.Printf("GeneralAmplifier for %s exits", gamp.name)
+				cancel()
+				closing = nil
+			case <-done:
+				log.Printf("%s: %s, stop sending", gamp.name, ctx.Err())
+				if started == 0 {
+					return
+				}
+				done, in = nil, nil
 			case trace := <-in:
+				// traces are amplified once
+				in = nil
+				// capture only, used to record archive
+				if gamp.threads <= 0 {
+					log.Printf("%s: traces captured", gamp.name)
+
+					return
+				}
+				if !gamp.startAt.IsZero() {
+					if wait := time.Until(gamp.startAt); wait > 0 {
+						log.Printf("%s: threads start in %s", gamp.name, wait)
+						select {
+						case <-time.After(wait):
+						case <-ctx.Done():
+							continue
+						}
+					} else {
+						log.Printf("%s: start time passed by %s", gamp.name, -wait)
+					}
+				}
+				gamp.stats.plan(gamp.threads, gamp.repeat)
 				for i := 1; i <= gamp.threads; i++ {
 					go gamp.ThreadRoutine(i, ctx, endpoint, gamp.repeat, trace, threadDown)
 				}
+				started = gamp.threads
 			case tdID := <-threadDown:
 				log.Printf("%s: thread: %d down", gamp.name, tdID)
-				if finished++; gamp.threads == finished {
+				if finished++; started == finished {
 					log.Printf("%s: all threds finished", gamp.name)
-					finish <- struct{}{}
 
 					return
 				}
@@ -81,10 +205,147 @@ func (gamp *GeneralAmplifier) StartThreads(ctx context.Context, endpoint string,
 	return
 }
 
+// deliver hands captured traces over to StartThreads, it gives up once the amplifier exits
+func (gamp *GeneralAmplifier) deliver(ready chan any, trace any) {
+	select {
+	case ready <- trace:
+	case <-gamp.exit:
+	}
+}
+
 func (gamp *GeneralAmplifier) ThreadRoutine(ID int, ctx context.Context, endpoint string, repeat int, trace any, threadDown chan int) error {
 	return gamp.threadRoutine(ID, ctx, endpoint, repeat, trace, threadDown)
 }
 
+// requestHeader merges captured header with headers configured for amplifier
+func (gamp *GeneralAmplifier) requestHeader(captured http.Header) http.Header {
+	header := cloneHeader(captured)
+	for k, v := range gamp.header {
+		header[k] = append([]string(nil), v...)
+	}
+	if gamp.compression != "" {
+		header.Set("Content-Encoding", gamp.compression)
+	}
+
+	return header
+}
+
+// send posts one replayed payload carrying spans, body is compressed as configured. The
+// payload is retried as configured and dropped after the last attempt or once ctx is done.
+func (gamp *GeneralAmplifier) send(ctx context.Context, ID, seq int, client *http.Client, endpoint string, header http.Header, body []byte, spans int) {
+	for attempt := 1; ; attempt++ {
+		resp, built := gamp.post(ID, seq, client, endpoint, header, body, spans)
+		if !built || gamp.retry == nil || !gamp.retry.retryable(resp) {
+			return
+		}
+		if attempt >= gamp.retry.MaxAttempts {
+			gamp.stats.drop(spans)
+			log.Printf("thread %d payload %d dropped after %d attempts", ID, seq, attempt)
+
+			return
+		}
+		select {
+		case <-time.After(gamp.retry.delay(attempt, resp)):
+		case <-ctx.Done():
+			gamp.stats.drop(spans)
+
+			return
+		}
+		gamp.stats.retried()
+	}
+}
+
+// post makes one attempt to send payload, resp is nil on transport error and built is
+// false if the request can not be built
+func (gamp *GeneralAmplifier) post(ID, seq int, client *http.Client, endpoint string, header http.Header, body []byte, spans int) (resp *http.Response, built bool) {
+	req, size, err := gamp.newRequest(endpoint, header, body)
+	if err != nil {
+		log.Println(err.Error())
+
+		return nil, false
+	}
+
+	gamp.stats.begin()
+	start := time.Now()
+	resp, err = do(client, gamp.stats.traceConn(req))
+	gamp.closeConn(client)
+	if err != nil {
+		log.Println(err.Error())
+		gamp.stats.end(ID, spans, size, nil, time.Since(start))
+
+		return nil, true
+	}
+	gamp.stats.end(ID, spans, size, resp, time.Since(start))
+	log.Printf("thread %d send %d times status: %s", ID, seq, resp.Status)
+
+	return resp, true
+}
+
+// newRequest builds the request replaying body, size is the length of body once compressed
+func (gamp *GeneralAmplifier) newRequest(endpoint string, header http.Header, body []byte) (req *http.Request, size int, err error) {
+	if body, err = compress(gamp.compression, body); err != nil {
+		return
+	}
+	req, err = http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		log.Fatalln(redactError(err))
+	}
+	req.Header = header
+
+	return req, len(body), nil
+}
+
+// do sends req and drains the response so that the connection is reused, the query of
+// request URL is redacted from errors since it may carry the auth token
+func do(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return resp, nil
+}
+
+// warmUp sends payloads of spans built by next until warm-up ends, on the client of the thread so
+// that its connections are open once measured requests start, see WithWarmup
+func (gamp *GeneralAmplifier) warmUp(ID int, ctx context.Context, client *http.Client, endpoint string, header http.Header, spans int, next func() ([]byte, error)) {
+	if gamp.warmupRequests <= 0 && gamp.warmupDuration <= 0 {
+		return
+	}
+
+	var (
+		start = time.Now()
+		sent  int
+	)
+	for ctx.Err() == nil {
+		if gamp.warmupRequests > 0 && sent >= gamp.warmupRequests {
+			break
+		}
+		if gamp.warmupDuration > 0 && time.Since(start) >= gamp.warmupDuration {
+			break
+		}
+		body, err := next()
+		if err != nil {
+			log.Println(err.Error())
+			break
+		}
+		req, _, err := gamp.newRequest(endpoint, header, body)
+		if err != nil {
+			log.Println(err.Error())
+			break
+		}
+		if _, err = do(client, req); err != nil {
+			log.Println(err.Error())
+		}
+		gamp.closeConn(client)
+		sent++
+	}
+	gamp.stats.warmedUp(sent, sent*spans)
+	log.Printf("thread %d warmed up with %d requests in %s", ID, sent, time.Since(start))
+}
+
 func (gamp *GeneralAmplifier) Close() {
 	select {
 	case <-gamp.close:
@@ -93,12 +354,18 @@ func (gamp *GeneralAmplifier) Close() {
 	}
 }
 
-func NewGeneralAmplifier(name string, threads, repeat int, handler AmplifierFunc) *GeneralAmplifier {
-	return &GeneralAmplifier{
+func NewGeneralAmplifier(name string, threads, repeat int, handler AmplifierFunc, opts ...AmplifierOption) *GeneralAmplifier {
+	gamp := &GeneralAmplifier{
 		name:          name,
 		threads:       threads,
 		repeat:        repeat,
 		threadRoutine: handler,
 		close:         make(chan struct{}),
+		exit:          make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(gamp)
+	}
+
+	return gamp
 }
@@ -0,0 +1,193 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/CodapeWild/devkit/comerr"
+)
+
+// protocols of payloads kept in archive
+const (
+	ProtocolDDTrace = "ddtrace"
+	ProtocolJaeger  = "jaeger"
+)
+
+// ArchiveRecord is one captured request, body is kept decompressed and undecoded
+// so that the archive can be replayed by any later version of this tool.
+type ArchiveRecord struct {
+	Protocol string      `json:"protocol"`
+	Pattern  string      `json:"pattern"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	Time     time.Time   `json:"time"`
+}
+
+func (rec *ArchiveRecord) request() (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, rec.Pattern, bytes.NewReader(rec.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = cloneHeader(rec.Header)
+
+	return req, nil
+}
+
+// ArchiveWriter appends records to archive file in JSON lines, it's safe for concurrent use.
+type ArchiveWriter struct {
+	sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func (aw *ArchiveWriter) Write(rec *ArchiveRecord) error {
+	aw.Lock()
+	defer aw.Unlock()
+
+	return aw.enc.Encode(rec)
+}
+
+func (aw *ArchiveWriter) Close() error {
+	aw.Lock()
+	defer aw.Unlock()
+
+	return aw.f.Close()
+}
+
+// NewArchiveWriter creates archive file or appends to the existing one
+func NewArchiveWriter(path string) (*ArchiveWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArchiveWriter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func LoadArchive(path string) ([]*ArchiveRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadArchive(f)
+}
+
+// ReadArchive reads records of archive in JSON lines from r
+func ReadArchive(r io.Reader) ([]*ArchiveRecord, error) {
+	var (
+		records []*ArchiveRecord
+		dec     = json.NewDecoder(bufio.NewReader(r))
+	)
+	for {
+		rec := &ArchiveRecord{}
+		if err := dec.Decode(rec); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// record saves request into archive if amplifier has one, request body is restored for later decoding
+func (gamp *GeneralAmplifier) record(protocol, pattern string, req *http.Request) error {
+	if gamp.archive == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	return gamp.archive.Write(&ArchiveRecord{
+		Protocol: protocol,
+		Pattern:  pattern,
+		Header:   req.Header,
+		Body:     body,
+		Time:     time.Now(),
+	})
+}
+
+// StartReplay amplifies archived requests towards endpoint without running any tracer,
+// all records must share the same protocol.
+func StartReplay(ctx context.Context, records []*ArchiveRecord, endpointAddress string, threads, repeat int, opts ...AmplifierOption) (context.CancelFunc, chan struct{}, error) {
+	if len(records) == 0 {
+		return nil, nil, comerr.ErrEmptyValue
+	}
+	protocol := records[0].Protocol
+	for _, rec := range records[1:] {
+		if rec.Protocol != protocol {
+			return nil, nil, fmt.Errorf("archive mixes protocols %s and %s", protocol, rec.Protocol)
+		}
+	}
+
+	var (
+		ready chan any
+		amp   *GeneralAmplifier
+		trace any
+		err   error
+	)
+	switch protocol {
+	case ProtocolDDTrace:
+		if trace, err = newDDReqFromArchive(records); err == nil {
+			ddamp := newDDAmplifier(0, threads, repeat, opts...)
+			amp, ready = ddamp.GeneralAmplifier, ddamp.ready
+		}
+	case ProtocolJaeger:
+		if trace, err = newJgReqFromArchive(records); err == nil {
+			jgamp := newJgAmplifier(endpointAddress, 0, threads, repeat, opts...)
+			amp, ready = jgamp.GeneralAmplifier, jgamp.ready
+		}
+	default:
+		err = comerr.ErrUnrecognizedParameters(protocol)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, canceler := context.WithCancel(ctx)
+	finish, err := amp.StartThreads(ctx, endpointAddress, ready)
+	if err != nil {
+		canceler()
+
+		return nil, nil, err
+	}
+	go amp.deliver(ready, trace)
+
+	return canceler, finish, nil
+}
@@ -0,0 +1,154 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestArchiveReplay(t *testing.T) {
+	var received int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		traces, err := decodeDDTraces(ddV04, req)
+		if err != nil || len(traces) != 2 {
+			t.Errorf("unexpected replayed payload: %v %v", traces, err)
+		}
+		atomic.AddInt32(&received, 1)
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "dd.jsonl")
+	archive, err := NewArchiveWriter(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, err := newTestDDTraces().MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	header := http.Header{"Content-Type": []string{"application/msgpack"}}
+	if err = archive.Write(&ArchiveRecord{Protocol: ProtocolDDTrace, Pattern: "/v0.4/traces", Header: header, Body: body, Time: time.Now()}); err != nil {
+		t.Fatal(err.Error())
+	}
+	archive.Close()
+
+	records, err := LoadArchive(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	canceler, finish, err := StartReplay(context.TODO(), records, srv.URL+"/v0.4/traces", 2, 3)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer canceler()
+
+	select {
+	case <-finish:
+	case <-time.After(10 * time.Second):
+		t.Fatal("replay timeout")
+	}
+	if c := atomic.LoadInt32(&received); c != 6 {
+		t.Fatalf("expect 6 requests got %d", c)
+	}
+}
+
+func TestReplayCancel(t *testing.T) {
+	var received int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&received, 1)
+		time.Sleep(20 * time.Millisecond)
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	body, err := newTestDDTraces().MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	records := []*ArchiveRecord{{Protocol: ProtocolDDTrace, Pattern: "/v0.4/traces", Body: body, Header: http.Header{"Content-Type": []string{"application/msgpack"}}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	stats := NewStats()
+	canceler, finish, err := StartReplay(ctx, records, srv.URL+"/v0.4/traces", 2, 1000, WithStats(stats))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer canceler()
+
+	select {
+	case <-finish:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay not stopped after cancel")
+	}
+	st := stats.Snapshot()
+	if st.Requests == 0 || st.Requests >= 2000 || st.InFlight != 0 || int32(st.Requests) != atomic.LoadInt32(&received) {
+		t.Fatalf("unexpected stats after cancel: %+v received: %d", st, atomic.LoadInt32(&received))
+	}
+}
+
+func TestReplayWarmup(t *testing.T) {
+	var received int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&received, 1)
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	body, err := newTestDDTraces().MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	records := []*ArchiveRecord{{Protocol: ProtocolDDTrace, Pattern: "/v0.4/traces", Body: body, Header: http.Header{"Content-Type": []string{"application/msgpack"}}}}
+	for _, c := range []struct {
+		requests int
+		duration time.Duration
+		warmup   int64
+	}{
+		{requests: 4, warmup: 8},
+		// requests limit reached first
+		{requests: 2, duration: time.Minute, warmup: 4},
+	} {
+		atomic.StoreInt32(&received, 0)
+		stats := NewStats()
+		canceler, finish, err := StartReplay(context.TODO(), records, srv.URL+"/v0.4/traces", 2, 3, WithStats(stats), WithWarmup(c.requests, c.duration))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		select {
+		case <-finish:
+		case <-time.After(10 * time.Second):
+			t.Fatal("replay timeout")
+		}
+		canceler()
+
+		st := stats.Snapshot()
+		if st.Requests != 6 || st.Latency.Count != 6 || st.Warmup != c.warmup || st.WarmupSpans != 4*c.warmup {
+			t.Fatalf("warm-up counted in stats: %+v", st)
+		}
+		if r := atomic.LoadInt32(&received); int64(r) != 6+c.warmup {
+			t.Fatalf("expect %d requests got %d", 6+c.warmup, r)
+		}
+	}
+}
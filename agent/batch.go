@@ -0,0 +1,90 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"fmt"
+)
+
+// units used to re-chunk captured traces into replayed payloads
+const (
+	BatchByTraces = "traces"
+	BatchBySpans  = "spans"
+	BatchByBytes  = "bytes"
+)
+
+func CheckBatch(by string, size int) error {
+	switch by {
+	case "":
+		return nil
+	case BatchByTraces, BatchBySpans, BatchByBytes:
+		if size <= 0 {
+			return fmt.Errorf("batch size must be positive when batching by %s", by)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unrecognized batch unit: %s", by)
+	}
+}
+
+// batchUnit describes one captured trace as seen by the batch builder
+type batchUnit struct {
+	spans, bytes int
+}
+
+// fillBatch returns the indexes of units, taken cyclically, that make up one payload
+// of the required size. Traces are never split, so a payload batched by spans or bytes
+// may exceed the size by less than one trace, and a payload always holds one trace at least.
+func fillBatch(units []batchUnit, by string, size int) []int {
+	if len(units) == 0 {
+		return nil
+	}
+
+	if by == "" || size <= 0 {
+		picked := make([]int, len(units))
+		for i := range picked {
+			picked[i] = i
+		}
+
+		return picked
+	}
+
+	var (
+		picked []int
+		total  int
+	)
+	for i := 0; total < size; i++ {
+		idx := i % len(units)
+		picked = append(picked, idx)
+		switch by {
+		case BatchBySpans:
+			total += units[idx].spans
+		case BatchByBytes:
+			total += units[idx].bytes
+		default:
+			total++
+		}
+		// guard against units carrying nothing
+		if i >= len(units) && total == 0 {
+			break
+		}
+	}
+
+	return picked
+}
@@ -0,0 +1,132 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Capture compares spans captured from tracer with spans expected by route, it's safe
+// for concurrent use.
+type Capture struct {
+	Expected int  `json:"expected_spans"`
+	Captured int  `json:"captured_spans"`
+	TimedOut bool `json:"timed_out,omitempty"`
+
+	mu sync.Mutex
+}
+
+func (c *Capture) expect(spans int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Expected = spans
+}
+
+func (c *Capture) add(spans int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Captured += spans
+}
+
+func (c *Capture) timeout() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.TimedOut = true
+}
+
+// Snapshot returns a copy for reporting
+func (c *Capture) Snapshot() *Capture {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &Capture{Expected: c.Expected, Captured: c.Captured, TimedOut: c.TimedOut}
+}
+
+// WithCapture reports spans captured from tracer into capture.
+func WithCapture(capture *Capture) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.capture = capture
+	}
+}
+
+// WithCaptureTimeout stops waiting for expected spans after timeout, captured traces are
+// amplified anyway if proceed is true, otherwise the amplifier exits without sending.
+func WithCaptureTimeout(timeout time.Duration, proceed bool) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.captureTimeout = timeout
+		gamp.captureProceed = proceed
+	}
+}
+
+// fire delivers the captured traces to StartThreads once, later calls are ignored
+func (gamp *GeneralAmplifier) fire(ready chan any, trace any) {
+	if atomic.CompareAndSwapInt32(&gamp.fired, 0, 1) {
+		gamp.deliver(ready, trace)
+	}
+}
+
+func (gamp *GeneralAmplifier) isFired() bool {
+	return atomic.LoadInt32(&gamp.fired) == 1
+}
+
+// watchCapture waits for traces to be fired until capture timeout, then proceeds with
+// what partial returns or closes the amplifier
+func (gamp *GeneralAmplifier) watchCapture(ready chan any, partial func() (trace any, spans int)) {
+	if gamp.captureTimeout <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(gamp.captureTimeout)
+	defer timer.Stop()
+	select {
+	case <-gamp.exit:
+		return
+	case <-timer.C:
+	}
+	if !atomic.CompareAndSwapInt32(&gamp.fired, 0, 1) {
+		return
+	}
+
+	gamp.capture.timeout()
+	trace, spans := partial()
+	if gamp.captureProceed && spans > 0 {
+		log.Printf("%s: capture timeout after %s, proceed with %d spans", gamp.name, gamp.captureTimeout, spans)
+		gamp.deliver(ready, trace)
+	} else {
+		log.Printf("%s: capture timeout after %s with %d spans captured", gamp.name, gamp.captureTimeout, spans)
+		gamp.Close()
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// content encodings supported by replayed requests and capture handlers
+const (
+	CompressGzip    = "gzip"
+	CompressDeflate = "deflate"
+	CompressZstd    = "zstd"
+)
+
+func CheckCompression(encoding string) error {
+	switch encoding {
+	case "", CompressGzip, CompressDeflate, CompressZstd:
+		return nil
+	default:
+		return fmt.Errorf("unrecognized compression: %s", encoding)
+	}
+}
+
+// compress encodes body with the given content encoding, an empty encoding returns body untouched
+func compress(encoding string, body []byte) ([]byte, error) {
+	if encoding == "" {
+		return body, nil
+	}
+
+	var (
+		buf = bytes.NewBuffer(make([]byte, 0, len(body)/2))
+		w   io.WriteCloser
+		err error
+	)
+	switch encoding {
+	case CompressGzip:
+		w = gzip.NewWriter(buf)
+	case CompressDeflate:
+		w = zlib.NewWriter(buf)
+	case CompressZstd:
+		if w, err = zstd.NewWriter(buf); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unrecognized compression: %s", encoding)
+	}
+	if _, err = w.Write(body); err != nil {
+		w.Close()
+
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (zrc zstdReadCloser) Close() error {
+	zrc.Decoder.Close()
+
+	return nil
+}
+
+// decompressBody replaces the request body with a decoding reader according to the
+// Content-Encoding header, so that handlers always read the raw payload. The header is
+// removed afterwards, captured headers are replayed with their own compression settings.
+func decompressBody(req *http.Request) error {
+	var (
+		encoding = strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+		body     io.ReadCloser
+		err      error
+	)
+	switch encoding {
+	case "", "identity":
+		return nil
+	case CompressGzip, "x-gzip":
+		body, err = gzip.NewReader(req.Body)
+	case CompressDeflate:
+		body, err = zlib.NewReader(req.Body)
+	case CompressZstd:
+		var dec *zstd.Decoder
+		if dec, err = zstd.NewReader(req.Body); err == nil {
+			body = zstdReadCloser{dec}
+		}
+	default:
+		return fmt.Errorf("unrecognized content encoding: %s", encoding)
+	}
+	if err != nil {
+		return err
+	}
+
+	req.Body = body
+	req.ContentLength = -1
+	req.Header.Del("Content-Encoding")
+	req.Header.Del("Content-Length")
+
+	return nil
+}
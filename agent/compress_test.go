@@ -0,0 +1,152 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	body := bytes.Repeat([]byte("dktrace-data-benchmark "), 100)
+	for _, encoding := range []string{"", CompressGzip, CompressDeflate, CompressZstd} {
+		buf, err := compress(encoding, body)
+		if err != nil {
+			t.Fatalf("%q: %s", encoding, err.Error())
+		}
+		if encoding != "" && len(buf) >= len(body) {
+			t.Fatalf("%q: body not compressed", encoding)
+		}
+
+		req := httptest.NewRequest("POST", "/v0.4/traces", bytes.NewBuffer(buf))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		if err = decompressBody(req); err != nil {
+			t.Fatalf("%q: %s", encoding, err.Error())
+		}
+		if req.Header.Get("Content-Encoding") != "" {
+			t.Fatalf("%q: Content-Encoding header not removed", encoding)
+		}
+		got, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("%q: %s", encoding, err.Error())
+		}
+		if !bytes.Equal(got, body) {
+			t.Fatalf("%q: body mismatch after decompression", encoding)
+		}
+	}
+
+	if err := CheckCompression("br"); err == nil {
+		t.Fatal("expect error for unsupported compression")
+	}
+}
+
+func TestCaptureCompressed(t *testing.T) {
+	ddbody, err := newTestDDTraces().MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	jgbody, err := encodeJgBinaryProtocol(newTestJgBatch())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, proto := range []struct {
+		name, path, contentType string
+		body                    []byte
+		// agent passes traces to ready once all the 4 test spans are captured
+		newAgent func() (http.Handler, chan any)
+	}{
+		{
+			name: "ddtrace", path: "/v0.4/traces", contentType: "application/msgpack", body: ddbody,
+			newAgent: func() (http.Handler, chan any) {
+				amp := newDDAmplifier(4, 0, 0)
+				amp.ready = make(chan any, 1)
+
+				return newDDAgent(amp), amp.ready
+			},
+		},
+		{
+			name: "jaeger", path: "/apis/traces", contentType: "application/x-thrift", body: jgbody,
+			newAgent: func() (http.Handler, chan any) {
+				amp := newJgAmplifier("", 4, 0, 0)
+				amp.ready = make(chan any, 1)
+
+				return newJgAgent(amp), amp.ready
+			},
+		},
+	} {
+		for _, c := range []struct {
+			encoding string
+			status   int
+			captured int
+		}{
+			{encoding: "", status: http.StatusOK, captured: 4},
+			{encoding: CompressGzip, status: http.StatusOK, captured: 4},
+			{encoding: CompressDeflate, status: http.StatusOK, captured: 4},
+			{encoding: CompressZstd, status: http.StatusOK, captured: 4},
+			{encoding: "br", status: http.StatusBadRequest},
+		} {
+			agent, ready := proto.newAgent()
+			srv := httptest.NewServer(agent)
+
+			body := proto.body
+			if c.encoding != "br" {
+				if body, err = compress(c.encoding, proto.body); err != nil {
+					t.Fatal(err.Error())
+				}
+			}
+			req, err := http.NewRequest(http.MethodPost, srv.URL+proto.path, bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			req.Header.Set("Content-Type", proto.contentType)
+			req.Header.Set("X-Datadog-Trace-Count", "2")
+			if c.encoding != "" {
+				req.Header.Set("Content-Encoding", c.encoding)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			resp.Body.Close()
+			srv.Close()
+
+			var captured int
+			select {
+			case trace := <-ready:
+				switch trace := trace.(type) {
+				case *ddReqWrapper:
+					for _, spans := range trace.traces {
+						captured += len(spans)
+					}
+				case *jgReqWrapper:
+					captured = len(trace.batch.Spans)
+				}
+			default:
+			}
+			if resp.StatusCode != c.status || captured != c.captured {
+				t.Fatalf("%s %q: expect status %d with %d spans captured got %d with %d", proto.name, c.encoding, c.status, c.captured, resp.StatusCode, captured)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+/*
+*   Copyright (c) 2023 CodapeWild
+*   All rights reserved.
+
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+
+*   http://www.apache.org/licenses/LICENSE-2.0
+
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+ */
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP/2 modes of ConnOptions
+const (
+	HTTP2TLS       = "h2"
+	HTTP2Cleartext = "h2c"
+)
+
+// defMaxConns bounds connections of a thread unless ConnOptions.MaxConns is set
+const defMaxConns = 100
+
+// ConnOptions tunes connections of amplifier threads towards collector, every thread
+// keeps its own connections. The zero value keeps connections alive with TCP keep-alive
+// probes, up to 100 connections and 10s to wait for response header over HTTP/1.1.
+type ConnOptions struct {
+	// disable TCP keep-alive probes on idle connections, HTTP connections are still reused
+	// unless NewConnPerRequest
+	DisableTCPKeepAlive bool
+	// connections of a thread, 100 if not positive, h2c multiplexes one connection and
+	// rejects it
+	MaxConns int
+	// HTTP/2 over TLS with h2 or over cleartext with h2c, HTTP/1.1 if empty
+	HTTP2 string
+	// bounds a request including reading response body if not zero
+	RequestTimeout time.Duration
+	// close the connection after every request, so that every request dials
+	NewConnPerRequest bool
+	// dial this Unix domain socket instead of the host of endpoint
+	UnixSocket string
+}
+
+// WithConn sets connection options of amplifier threads, see ConnOptions.
+func WithConn(conn *ConnOptions) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.conn = conn
+	}
+}
+
+// CheckConn checks HTTP/2 mode of conn against the scheme of endpoint, https or http, and
+// max connections against the mode.
+func CheckConn(conn *ConnOptions, scheme string) error {
+	if conn == nil {
+		return nil
+	}
+
+	switch conn.HTTP2 {
+	case "":
+	case HTTP2TLS:
+		if scheme != "https" {
+			return fmt.Errorf("http2 %s requires https collector", conn.HTTP2)
+		}
+	case HTTP2Cleartext:
+		if scheme != "http" {
+			return fmt.Errorf("http2 %s requires http collector", conn.HTTP2)
+		}
+	default:
+		return fmt.Errorf("unsupported http2 mode: %s, %s or %s expected", conn.HTTP2, HTTP2TLS, HTTP2Cleartext)
+	}
+	if conn.MaxConns < 0 {
+		return fmt.Errorf("negative max connections: %d", conn.MaxConns)
+	}
+	if conn.MaxConns > 0 && conn.HTTP2 == HTTP2Cleartext {
+		return fmt.Errorf("max connections not supported by http2 %s", HTTP2Cleartext)
+	}
+
+	return nil
+}
+
+// newSingleHostTransport builds the transport of one thread, conn may be nil for defaults
+func newSingleHostTransport(tlsConf *tls.Config, conn *ConnOptions) http.RoundTripper {
+	if conn == nil {
+		conn = &ConnOptions{}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if conn.DisableTCPKeepAlive {
+		dialer.KeepAlive = -1
+	}
+	dial := dialer.DialContext
+	if conn.UnixSocket != "" {
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", conn.UnixSocket)
+		}
+	}
+	if conn.HTTP2 == HTTP2Cleartext {
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}
+	}
+
+	maxConns := conn.MaxConns
+	if maxConns <= 0 {
+		maxConns = defMaxConns
+	}
+	headerTimeout := 10 * time.Second
+	if conn.RequestTimeout > 0 {
+		headerTimeout = 0
+	}
+
+	return &http.Transport{
+		TLSClientConfig:       tlsConf,
+		TLSHandshakeTimeout:   10 * time.Second,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     conn.HTTP2 == HTTP2TLS,
+		DisableKeepAlives:     conn.NewConnPerRequest,
+		MaxIdleConnsPerHost:   maxConns,
+		MaxConnsPerHost:       maxConns,
+		IdleConnTimeout:       10 * time.Second,
+		ResponseHeaderTimeout: headerTimeout,
+		ExpectContinueTimeout: time.Second,
+		WriteBufferSize:       10 * 1024,
+	}
+}
+
+// newClient builds the client of one thread as configured for amplifier
+func (gamp *GeneralAmplifier) newClient() *http.Client {
+	client := &http.Client{Transport: newSingleHostTransport(gamp.tlsConfig, gamp.conn)}
+	if gamp.conn != nil {
+		client.Timeout = gamp.conn.RequestTimeout
+	}
+
+	return client
+}
+
+// closeConn closes connections of client after a request if every request dials
+func (gamp *GeneralAmplifier) closeConn(client *http.Client) {
+	if gamp.conn != nil && gamp.conn.NewConnPerRequest {
+		client.CloseIdleConnections()
+	}
+}
+
+// traceConn counts connections dialed or reused by req into stats
+func (st *Stats) traceConn(req *http.Request) *http.Request {
+	if st == nil {
+		return req
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&st.Reuses, 1)
+			} else {
+				atomic.AddInt64(&st.Dials, 1)
+			}
+		},
+	}))
+}
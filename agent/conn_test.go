@@ -0,0 +1,120 @@
+/*
+*   Copyright (c) 2023 CodapeWild
+*   All rights reserved.
+
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+
+*   http://www.apache.org/licenses/LICENSE-2.0
+
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+ */
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestReplayConn(t *testing.T) {
+	var (
+		proto   atomic.Value
+		handler = http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			proto.Store(req.Proto)
+			resp.WriteHeader(http.StatusOK)
+		})
+		plain  = httptest.NewServer(handler)
+		tlsrv  = httptest.NewUnstartedServer(handler)
+		h2csrv = httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	)
+	defer plain.Close()
+	tlsrv.EnableHTTP2 = true
+	tlsrv.StartTLS()
+	defer tlsrv.Close()
+	defer h2csrv.Close()
+
+	socket := filepath.Join(t.TempDir(), "collector.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	unixsrv := &http.Server{Handler: handler}
+	go unixsrv.Serve(l)
+	defer unixsrv.Close()
+
+	body, err := newTestDDTraces().MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	records := []*ArchiveRecord{{Protocol: ProtocolDDTrace, Pattern: "/v0.4/traces", Body: body, Header: http.Header{"Content-Type": []string{"application/msgpack"}}}}
+	for name, c := range map[string]struct {
+		url    string
+		conn   *ConnOptions
+		opts   []AmplifierOption
+		proto  string
+		dials  int64
+		reuses int64
+	}{
+		"keep-alive":           {url: plain.URL, proto: "HTTP/1.1", dials: 1, reuses: 4},
+		"new conn per request": {url: plain.URL, conn: &ConnOptions{NewConnPerRequest: true, DisableTCPKeepAlive: true}, proto: "HTTP/1.1", dials: 5},
+		"h2":                   {url: tlsrv.URL, conn: &ConnOptions{HTTP2: HTTP2TLS}, opts: []AmplifierOption{WithTLS(tlsrv.Client().Transport.(*http.Transport).TLSClientConfig)}, proto: "HTTP/2.0", dials: 1, reuses: 4},
+		"h2c":                  {url: h2csrv.URL, conn: &ConnOptions{HTTP2: HTTP2Cleartext, RequestTimeout: time.Second}, proto: "HTTP/2.0", dials: 1, reuses: 4},
+		"unix socket":          {url: "http://unix", conn: &ConnOptions{UnixSocket: socket, MaxConns: 1}, proto: "HTTP/1.1", dials: 1, reuses: 4},
+	} {
+		stats := NewStats()
+		opts := append([]AmplifierOption{WithStats(stats), WithConn(c.conn)}, c.opts...)
+		canceler, finish, err := StartReplay(context.TODO(), records, c.url+"/v0.4/traces", 1, 5, opts...)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		select {
+		case <-finish:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: replay timeout", name)
+		}
+		canceler()
+
+		st := stats.Snapshot()
+		if st.Requests != 5 || st.Errors != 0 || st.Dials != c.dials || st.Reuses != c.reuses {
+			t.Fatalf("%s: unexpected stats: %+v", name, st)
+		}
+		if p := proto.Load(); p != c.proto {
+			t.Fatalf("%s: expect %s got %v", name, c.proto, p)
+		}
+	}
+}
+
+func TestCheckConn(t *testing.T) {
+	for _, c := range []struct {
+		conn   *ConnOptions
+		scheme string
+		ok     bool
+	}{
+		{conn: nil, scheme: "http", ok: true},
+		{conn: &ConnOptions{HTTP2: HTTP2TLS}, scheme: "https", ok: true},
+		{conn: &ConnOptions{HTTP2: HTTP2TLS}, scheme: "http"},
+		{conn: &ConnOptions{HTTP2: HTTP2Cleartext}, scheme: "https"},
+		{conn: &ConnOptions{HTTP2: "h3"}, scheme: "https"},
+		{conn: &ConnOptions{MaxConns: -1}, scheme: "http"},
+		{conn: &ConnOptions{HTTP2: HTTP2Cleartext, MaxConns: 2}, scheme: "http"},
+		{conn: &ConnOptions{HTTP2: HTTP2TLS, MaxConns: 2}, scheme: "https", ok: true},
+	} {
+		if err := CheckConn(c.conn, c.scheme); (err == nil) != c.ok {
+			t.Fatalf("unexpected check result of %+v over %s: %v", c.conn, c.scheme, err)
+		}
+	}
+}
@@ -27,6 +27,8 @@ import (
 	"math/rand"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/CodapeWild/devkit/bufpool"
 	"github.com/CodapeWild/devkit/comerr"
@@ -53,14 +55,33 @@ var (
 
 type DDAgent struct {
 	http.ServeMux
+	server *http.Server
 }
 
-func (ddagt *DDAgent) Start(addr string) {
+// Start listens on addr, a TCP address or a Unix socket as UnixAddress, and serves captured requests in background until Shutdown
+func (ddagt *DDAgent) Start(addr string) error {
+	listener, err := listen(addr)
+	if err != nil {
+		return err
+	}
+
+	ddagt.server = &http.Server{Handler: ddagt}
 	go func() {
-		if err := http.ListenAndServe(addr, ddagt); err != nil {
-			log.Fatalln(err.Error())
+		if err := ddagt.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println(err.Error())
 		}
 	}()
+
+	return nil
+}
+
+// Shutdown stops listening and waits for requests being captured to complete
+func (ddagt *DDAgent) Shutdown(ctx context.Context) error {
+	if ddagt.server == nil {
+		return nil
+	}
+
+	return ddagt.server.Shutdown(ctx)
 }
 
 func newDDAgent(amp *ddAmplifier) *DDAgent {
@@ -83,6 +104,13 @@ func handleDDTracesWrapper(pattern, version string, amp *ddAmplifier) http.Handl
 			log.Printf("%s: %v", k, v)
 		}
 
+		if err := decompressBody(req); err != nil {
+			log.Println(err.Error())
+			reply(pattern, version, resp, err)
+
+			return
+		}
+
 		tc := countTraces(req)
 		if tc == 0 {
 			resp.WriteHeader(http.StatusOK)
@@ -90,34 +118,12 @@ func handleDDTracesWrapper(pattern, version string, amp *ddAmplifier) http.Handl
 			return
 		}
 
-		var (
-			traces pb.Traces
-			err    error
-		)
-		switch version {
-		case ddV01:
-			var spans []*pb.Span
-			if err = json.NewDecoder(req.Body).Decode(&spans); err == nil {
-				traces = append(traces, pb.Trace(spans))
-			}
-		case ddV02, ddV03, ddV04:
-			traces, err = decodeDDRequest(req)
-		case ddV05:
-			bufpool.MakeUseOfBuffer(func(buf *bytes.Buffer) {
-				if _, err = io.Copy(buf, req.Body); err == nil {
-					err = traces.UnmarshalMsgDictionary(buf.Bytes())
-				}
-			})
-		case ddV07:
-			bufpool.MakeUseOfBuffer(func(buf *bytes.Buffer) {
-				if _, err = io.Copy(buf, req.Body); err == nil {
-					_, err = traces.UnmarshalMsg(buf.Bytes())
-				}
-			})
-		default:
-			err = comerr.ErrUnrecognizedParameters(version)
+		if err := amp.record(ProtocolDDTrace, pattern, req); err != nil {
+			log.Println(err.Error())
 		}
 
+		traces, err := decodeDDTraces(version, req)
+
 		// reply ok or error based on parameter err
 		reply(pattern, version, resp, err)
 		if err != nil {
@@ -134,6 +140,38 @@ func handleDDTracesWrapper(pattern, version string, amp *ddAmplifier) http.Handl
 	}
 }
 
+func decodeDDTraces(version string, req *http.Request) (pb.Traces, error) {
+	var (
+		traces pb.Traces
+		err    error
+	)
+	switch version {
+	case ddV01:
+		var spans []*pb.Span
+		if err = json.NewDecoder(req.Body).Decode(&spans); err == nil {
+			traces = append(traces, pb.Trace(spans))
+		}
+	case ddV02, ddV03, ddV04:
+		traces, err = decodeDDRequest(req)
+	case ddV05:
+		bufpool.MakeUseOfBuffer(func(buf *bytes.Buffer) {
+			if _, err = io.Copy(buf, req.Body); err == nil {
+				err = traces.UnmarshalMsgDictionary(buf.Bytes())
+			}
+		})
+	case ddV07:
+		bufpool.MakeUseOfBuffer(func(buf *bytes.Buffer) {
+			if _, err = io.Copy(buf, req.Body); err == nil {
+				_, err = traces.UnmarshalMsg(buf.Bytes())
+			}
+		})
+	default:
+		err = comerr.ErrUnrecognizedParameters(version)
+	}
+
+	return traces, err
+}
+
 func reply(pattern, version string, resp http.ResponseWriter, err error) {
 	if err == nil {
 		resp.WriteHeader(http.StatusOK)
@@ -188,6 +226,7 @@ type ddReqWrapper struct {
 }
 
 type ddAmplifier struct {
+	sync.Mutex
 	*GeneralAmplifier
 	expectedSpansCount, receivedSpansCount int
 	header                                 http.Header
@@ -196,54 +235,93 @@ type ddAmplifier struct {
 }
 
 func (ddamp *ddAmplifier) AppendTrace(ddreq *ddReqWrapper) {
-	ddamp.header = dkhttp.MergeHeaders(ddamp.header, ddreq.header)
-	ddamp.traces = append(ddamp.traces, ddreq.traces...)
+	spans := 0
 	for _, trace := range ddreq.traces {
-		ddamp.receivedSpansCount += len(trace)
+		spans += len(trace)
 	}
-	if ddamp.receivedSpansCount >= ddamp.expectedSpansCount {
-		ddamp.ready <- &ddReqWrapper{header: ddamp.header, traces: ddamp.traces}
+	ddamp.capture.add(spans)
+
+	ddamp.Lock()
+	// traces arriving after fired are counted only
+	if ddamp.isFired() {
+		ddamp.Unlock()
+
+		return
+	}
+	ddamp.header = dkhttp.MergeHeaders(ddamp.header, ddreq.header)
+	ddamp.traces = append(ddamp.traces, ddreq.traces...)
+	ddamp.receivedSpansCount += spans
+	complete := ddamp.receivedSpansCount >= ddamp.expectedSpansCount
+	trace := &ddReqWrapper{header: ddamp.header, traces: ddamp.traces}
+	ddamp.Unlock()
+
+	if complete {
+		ddamp.fire(ddamp.ready, trace)
 	}
 }
 
+func (ddamp *ddAmplifier) partial() (any, int) {
+	ddamp.Lock()
+	defer ddamp.Unlock()
+
+	return &ddReqWrapper{header: ddamp.header, traces: ddamp.traces}, ddamp.receivedSpansCount
+}
+
 func (ddamp *ddAmplifier) StartThreads(ctx context.Context, endpoint string) (finish chan struct{}, err error) {
-	return ddamp.GeneralAmplifier.StartThreads(ctx, endpoint, ddamp.ready)
+	if finish, err = ddamp.GeneralAmplifier.StartThreads(ctx, endpoint, ddamp.ready); err == nil {
+		go ddamp.watchCapture(ddamp.ready, ddamp.partial)
+	}
+
+	return
 }
 
-func ddAmplifierThread(ID int, ctx context.Context, endpoint string, repeat int, trace any, threadDown chan int) error {
+func (ddamp *ddAmplifier) amplifierThread(ID int, ctx context.Context, endpoint string, repeat int, trace any, threadDown chan int) error {
 	ddreq, ok := trace.(*ddReqWrapper)
 	if !ok {
 		return comerr.ErrAssertFailed
 	}
 
 	var (
-		client  = &http.Client{Transport: newSingleHostTransport()}
-		replica = duplicateDDTraces(ddreq.traces)
+		client  = ddamp.newClient()
+		replica = batchDDTraces(ddreq.traces, ddamp.batchBy, ddamp.batchSize)
+		header  = ddamp.requestHeader(ddreq.header)
 	)
-	for i := 1; i <= repeat; i++ {
-		if buf, err := replica.MarshalMsg(nil); err != nil {
-			log.Println(err.Error())
-		} else {
-			req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(buf))
-			if err != nil {
-				log.Fatalln(err)
-			}
-			req.Header = ddreq.header
-			resp, err := client.Do(req)
-			if err != nil {
-				log.Println(err.Error())
-			} else {
-				log.Printf("thread %d send %d times status: %s", ID, i, resp.Status)
-				resp.Body.Close()
-			}
-		}
-		changeDDTracesIDs(replica)
+	header.Set("X-Datadog-Trace-Count", strconv.Itoa(len(replica)))
+	spans := 0
+	for _, trace := range replica {
+		spans += len(trace)
+	}
+	// every payload carries new IDs
+	next := func() ([]byte, error) {
+		defer changeDDTracesIDs(replica)
+
+		return replica.MarshalMsg(nil)
 	}
+	ddamp.warmUp(ID, ctx, client, endpoint, header, spans, next)
+	ddamp.sendAll(ID, ctx, client, endpoint, header, repeat, spans, next)
 	threadDown <- ID
 
 	return nil
 }
 
+// batchDDTraces builds the payload replayed by one thread out of the captured traces,
+// every trace in the payload is a deep copy with its own IDs.
+func batchDDTraces(traces pb.Traces, by string, size int) pb.Traces {
+	units := make([]batchUnit, len(traces))
+	for i, trace := range traces {
+		units[i] = batchUnit{spans: len(trace), bytes: trace.Msgsize()}
+	}
+
+	var batch pb.Traces
+	for _, idx := range fillBatch(units, by, size) {
+		dupli := duplicateDDTraces(pb.Traces{traces[idx]})
+		changeDDTracesIDs(dupli)
+		batch = append(batch, dupli...)
+	}
+
+	return batch
+}
+
 func duplicateDDTraces(traces pb.Traces) pb.Traces {
 	var dupli *pb.Traces = &pb.Traces{}
 	bufpool.MakeUseOfBuffer(func(buf *bytes.Buffer) {
@@ -260,43 +338,88 @@ func duplicateDDTraces(traces pb.Traces) pb.Traces {
 
 func changeDDTracesIDs(traces pb.Traces) {
 	for i := range traces {
-		var newtid = rand.Uint64()
+		var (
+			newtid = rand.Uint64()
+			sids   = make(map[uint64]uint64, len(traces[i]))
+		)
 		for j := range traces[i] {
 			traces[i][j].TraceID = newtid
-			var (
-				newsid = rand.Uint64()
-				oldsid = traces[i][j].SpanID
-			)
+			newsid := rand.Uint64()
+			sids[traces[i][j].SpanID] = newsid
 			traces[i][j].SpanID = newsid
-			for k := j + 1; k < len(traces[i]); k++ {
-				if traces[i][k].ParentID == oldsid {
-					traces[i][k].ParentID = newsid
-					break
-				}
+		}
+		// spans are not ordered in trace, relink parents after all IDs changed
+		for j := range traces[i] {
+			if newsid, ok := sids[traces[i][j].ParentID]; ok {
+				traces[i][j].ParentID = newsid
 			}
 		}
 	}
 }
 
-func newDDAmplifier(expectedSpansCount, threads, repeat int) *ddAmplifier {
-	return &ddAmplifier{
-		GeneralAmplifier:   NewGeneralAmplifier("ddtrace", threads, repeat, ddAmplifierThread),
+func newDDAmplifier(expectedSpansCount, threads, repeat int, opts ...AmplifierOption) *ddAmplifier {
+	ddamp := &ddAmplifier{
 		expectedSpansCount: expectedSpansCount,
 		ready:              make(chan any),
 	}
+	ddamp.GeneralAmplifier = NewGeneralAmplifier("ddtrace", threads, repeat, ddamp.amplifierThread, opts...)
+	ddamp.capture.expect(expectedSpansCount)
+
+	return ddamp
+}
+
+// newDDReqFromArchive merges archived ddtrace requests into one payload
+func newDDReqFromArchive(records []*ArchiveRecord) (*ddReqWrapper, error) {
+	ddreq := &ddReqWrapper{}
+	for _, rec := range records {
+		version, ok := ddPatternVersion[rec.Pattern]
+		if !ok {
+			return nil, comerr.ErrUnrecognizedParameters(rec.Pattern)
+		}
+		req, err := rec.request()
+		if err != nil {
+			return nil, err
+		}
+		traces, err := decodeDDTraces(version, req)
+		if err != nil {
+			return nil, err
+		}
+		ddreq.header = dkhttp.MergeHeaders(ddreq.header, rec.Header)
+		ddreq.traces = append(ddreq.traces, traces...)
+	}
+	if len(ddreq.traces) == 0 {
+		return nil, comerr.ErrEmptyValue
+	}
+
+	return ddreq, nil
 }
 
-func StartDDAgent(agentAddress, endpointAddress string, expectedSpansCount, threads, repeat int) (context.CancelFunc, chan struct{}, error) {
-	ctx, canceler := context.WithCancel(context.TODO())
+func StartDDAgent(ctx context.Context, agentAddress, endpointAddress string, expectedSpansCount, threads, repeat int, opts ...AmplifierOption) (context.CancelFunc, chan struct{}, error) {
+	ctx, canceler := context.WithCancel(ctx)
 
-	ampf := newDDAmplifier(expectedSpansCount, threads, repeat)
+	ampf := newDDAmplifier(expectedSpansCount, threads, repeat, opts...)
 	finish, err := ampf.StartThreads(ctx, endpointAddress)
 	if err != nil {
+		canceler()
+
 		return nil, nil, err
 	}
 
 	agent := newDDAgent(ampf)
-	agent.Start(agentAddress)
+	if err = agent.Start(agentAddress); err != nil {
+		canceler()
+
+		return nil, nil, err
+	}
+	// capture server is not needed any more once amplifier exits
+	go func() {
+		<-ampf.exit
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := agent.Shutdown(sctx); err != nil {
+			log.Println(err.Error())
+		}
+	}()
 
 	return canceler, finish, nil
 }
@@ -18,8 +18,133 @@
 package agent
 
 import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
 )
 
 func TestDDAgent(t *testing.T) {
 }
+
+func newTestDDTraces() pb.Traces {
+	return pb.Traces{
+		{
+			{TraceID: 1, SpanID: 12, ParentID: 11, Name: "child"},
+			{TraceID: 1, SpanID: 11, Name: "root"},
+			{TraceID: 1, SpanID: 13, ParentID: 11, Name: "child"},
+		},
+		{
+			{TraceID: 2, SpanID: 21, Name: "root"},
+		},
+	}
+}
+
+func TestBatchDDTraces(t *testing.T) {
+	traces := newTestDDTraces()
+	cases := []struct {
+		by           string
+		size         int
+		traces, span int
+	}{
+		{by: "", size: 0, traces: 2, span: 4},
+		{by: BatchByTraces, size: 5, traces: 5, span: 3 + 1 + 3 + 1 + 3},
+		{by: BatchBySpans, size: 4, traces: 2, span: 4},
+		{by: BatchBySpans, size: 5, traces: 3, span: 7},
+		{by: BatchByBytes, size: 1, traces: 1, span: 3},
+	}
+	for _, c := range cases {
+		batch := batchDDTraces(traces, c.by, c.size)
+		if len(batch) != c.traces {
+			t.Fatalf("batch by %q size %d: expect %d traces got %d", c.by, c.size, c.traces, len(batch))
+		}
+
+		var (
+			spans = 0
+			tids  = make(map[uint64]bool)
+		)
+		for _, trace := range batch {
+			spans += len(trace)
+			if tids[trace[0].TraceID] {
+				t.Fatalf("batch by %q size %d: duplicated trace ID", c.by, c.size)
+			}
+			tids[trace[0].TraceID] = true
+		}
+		if spans != c.span {
+			t.Fatalf("batch by %q size %d: expect %d spans got %d", c.by, c.size, c.span, spans)
+		}
+	}
+}
+
+func TestChangeDDTracesIDs(t *testing.T) {
+	traces := newTestDDTraces()
+	changeDDTracesIDs(traces)
+
+	root := traces[0][1]
+	if root.SpanID == 11 || root.ParentID != 0 {
+		t.Fatalf("root span not changed properly: %v", root)
+	}
+	for _, i := range []int{0, 2} {
+		if traces[0][i].ParentID != root.SpanID || traces[0][i].TraceID != root.TraceID {
+			t.Fatalf("child span not relinked: %v", traces[0][i])
+		}
+	}
+}
+
+func TestDDCaptureTimeout(t *testing.T) {
+	var received int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&received, 1)
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	body, err := newTestDDTraces().MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, proceed := range []bool{true, false} {
+		atomic.StoreInt32(&received, 0)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		agentAddress := listener.Addr().String()
+		listener.Close()
+
+		capture := &Capture{}
+		canceler, finish, err := StartDDAgent(context.TODO(), agentAddress, srv.URL+"/v0.4/traces", 10, 1, 1, WithCapture(capture), WithCaptureTimeout(200*time.Millisecond, proceed))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		req, _ := http.NewRequest(http.MethodPost, "http://"+agentAddress+"/v0.4/traces", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/msgpack")
+		req.Header.Set("X-Datadog-Trace-Count", "2")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		resp.Body.Close()
+
+		select {
+		case <-finish:
+		case <-time.After(5 * time.Second):
+			t.Fatal("capture timeout not fired")
+		}
+		canceler()
+
+		c := capture.Snapshot()
+		if c.Expected != 10 || c.Captured != 4 || !c.TimedOut {
+			t.Fatalf("unexpected capture: %+v", c)
+		}
+		if expect := map[bool]int32{true: 1, false: 0}[proceed]; atomic.LoadInt32(&received) != expect {
+			t.Fatalf("proceed: %v expect %d requests got %d", proceed, expect, atomic.LoadInt32(&received))
+		}
+	}
+}
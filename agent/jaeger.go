@@ -24,6 +24,8 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/CodapeWild/devkit/comerr"
 	dkhttp "github.com/CodapeWild/devkit/net/http"
@@ -40,14 +42,33 @@ var (
 
 type JgAgent struct {
 	http.ServeMux
+	server *http.Server
 }
 
-func (jga *JgAgent) Start(addr string) {
+// Start listens on addr, a TCP address or a Unix socket as UnixAddress, and serves captured requests in background until Shutdown
+func (jga *JgAgent) Start(addr string) error {
+	listener, err := listen(addr)
+	if err != nil {
+		return err
+	}
+
+	jga.server = &http.Server{Handler: jga}
 	go func() {
-		if err := http.ListenAndServe(addr, jga); err != nil {
-			log.Fatalln(err.Error())
+		if err := jga.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println(err.Error())
 		}
 	}()
+
+	return nil
+}
+
+// Shutdown stops listening and waits for requests being captured to complete
+func (jga *JgAgent) Shutdown(ctx context.Context) error {
+	if jga.server == nil {
+		return nil
+	}
+
+	return jga.server.Shutdown(ctx)
 }
 
 func newJgAgent(amp *jgAmplifier) *JgAgent {
@@ -70,6 +91,17 @@ func handleJgTracesWrapper(pattern, version string, amp *jgAmplifier) http.Handl
 			log.Printf("%s: %v", k, v)
 		}
 
+		if err := decompressBody(req); err != nil {
+			log.Println(err.Error())
+			resp.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		if err := amp.record(ProtocolJaeger, pattern, req); err != nil {
+			log.Println(err.Error())
+		}
+
 		var (
 			batch *jaeger.Batch
 			err   error
@@ -131,6 +163,7 @@ type jgReqWrapper struct {
 }
 
 type jgAmplifier struct {
+	sync.Mutex
 	*GeneralAmplifier
 	expectedSpansCount, receivedSpansCount int
 	header                                 http.Header
@@ -142,7 +175,15 @@ func (jgamp *jgAmplifier) AppendTrace(jgreq *jgReqWrapper) {
 	if jgreq.batch == nil || len(jgreq.batch.Spans) == 0 {
 		return
 	}
+	jgamp.capture.add(len(jgreq.batch.Spans))
 
+	jgamp.Lock()
+	// spans arriving after fired are counted only
+	if jgamp.isFired() {
+		jgamp.Unlock()
+
+		return
+	}
 	jgamp.header = dkhttp.MergeHeaders(jgamp.header, jgreq.header)
 	if jgamp.batch == nil {
 		jgamp.batch = jgreq.batch
@@ -150,13 +191,28 @@ func (jgamp *jgAmplifier) AppendTrace(jgreq *jgReqWrapper) {
 		jgamp.batch.Spans = append(jgamp.batch.Spans, jgreq.batch.Spans...)
 	}
 	jgamp.receivedSpansCount += len(jgreq.batch.Spans)
-	if jgamp.receivedSpansCount >= jgamp.expectedSpansCount {
-		jgamp.ready <- &jgReqWrapper{header: jgamp.header, batch: jgamp.batch}
+	complete := jgamp.receivedSpansCount >= jgamp.expectedSpansCount
+	trace := &jgReqWrapper{header: jgamp.header, batch: jgamp.batch}
+	jgamp.Unlock()
+
+	if complete {
+		jgamp.fire(jgamp.ready, trace)
 	}
 }
 
+func (jgamp *jgAmplifier) partial() (any, int) {
+	jgamp.Lock()
+	defer jgamp.Unlock()
+
+	return &jgReqWrapper{header: jgamp.header, batch: jgamp.batch}, jgamp.receivedSpansCount
+}
+
 func (jgamp *jgAmplifier) StartThreads(ctx context.Context, endpoint string) (finish chan struct{}, err error) {
-	return jgamp.GeneralAmplifier.StartThreads(ctx, endpoint, jgamp.ready)
+	if finish, err = jgamp.GeneralAmplifier.StartThreads(ctx, endpoint, jgamp.ready); err == nil {
+		go jgamp.watchCapture(jgamp.ready, jgamp.partial)
+	}
+
+	return
 }
 
 func (jgamp *jgAmplifier) Close() {
@@ -167,40 +223,66 @@ func (jgamp *jgAmplifier) Close() {
 	}
 }
 
-func jgAmplifierThread(ID int, ctx context.Context, endpoint string, repeat int, trace any, threadDown chan int) error {
+func (jgamp *jgAmplifier) amplifierThread(ID int, ctx context.Context, endpoint string, repeat int, trace any, threadDown chan int) error {
 	jgreq, ok := trace.(*jgReqWrapper)
 	if !ok {
 		return comerr.ErrAssertFailed
 	}
 
 	var (
-		client  = &http.Client{Transport: newSingleHostTransport()}
-		replica = duplicateJgBatch(jgreq.batch)
+		client  = jgamp.newClient()
+		replica = batchJgSpans(jgreq.batch, jgamp.batchBy, jgamp.batchSize)
+		header  = jgamp.requestHeader(jgreq.header)
 	)
-	for i := 1; i <= repeat; i++ {
-		if buf, err := encodeJgBinaryProtocol(replica); err != nil {
-			log.Println(err.Error())
-		} else {
-			req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(buf))
-			if err != nil {
-				log.Fatalln(err)
-			}
-			req.Header = jgreq.header
-			resp, err := client.Do(req)
-			if err != nil {
-				log.Println(err.Error())
-			} else {
-				log.Printf("thread %d send %d times status: %s", ID, i, resp.Status)
-				resp.Body.Close()
-			}
-		}
-		changeJgTraceIDs(replica)
+	// every payload carries new IDs
+	next := func() ([]byte, error) {
+		defer changeJgTraceIDs(replica)
+
+		return encodeJgBinaryProtocol(replica)
 	}
+	jgamp.warmUp(ID, ctx, client, endpoint, header, len(replica.Spans), next)
+	jgamp.sendAll(ID, ctx, client, endpoint, header, repeat, len(replica.Spans), next)
 	threadDown <- ID
 
 	return nil
 }
 
+// batchJgSpans builds the batch replayed by one thread out of the captured spans grouped
+// by trace, every trace in the batch is a deep copy with its own IDs.
+func batchJgSpans(batch *jaeger.Batch, by string, size int) *jaeger.Batch {
+	var (
+		traces [][]*jaeger.Span
+		index  = make(map[[2]int64]int)
+	)
+	for _, span := range batch.Spans {
+		k := [2]int64{span.TraceIdHigh, span.TraceIdLow}
+		i, ok := index[k]
+		if !ok {
+			i = len(traces)
+			index[k] = i
+			traces = append(traces, nil)
+		}
+		traces[i] = append(traces[i], span)
+	}
+
+	units := make([]batchUnit, len(traces))
+	for i, spans := range traces {
+		units[i].spans = len(spans)
+		if buf, err := encodeJgBinaryProtocol(&jaeger.Batch{Process: batch.Process, Spans: spans}); err == nil {
+			units[i].bytes = len(buf)
+		}
+	}
+
+	payload := &jaeger.Batch{Process: batch.Process}
+	for _, idx := range fillBatch(units, by, size) {
+		dupli := duplicateJgBatch(&jaeger.Batch{Process: batch.Process, Spans: traces[idx]})
+		changeJgTraceIDs(dupli)
+		payload.Spans = append(payload.Spans, dupli.Spans...)
+	}
+
+	return payload
+}
+
 func duplicateJgBatch(batch *jaeger.Batch) *jaeger.Batch {
 	buf, err := encodeJgBinaryProtocol(batch)
 	if err != nil {
@@ -216,46 +298,92 @@ func duplicateJgBatch(batch *jaeger.Batch) *jaeger.Batch {
 
 func changeJgTraceIDs(batch *jaeger.Batch) {
 	var (
-		newtidh = rand.Int63()
-		newtidl = rand.Int63()
+		tids = make(map[[2]int64][2]int64)
+		sids = make(map[[3]int64]int64)
+		olds = make([][2]int64, len(batch.Spans))
 	)
-	for _, span := range batch.Spans {
-		span.TraceIdHigh = newtidh
-		span.TraceIdLow = newtidl
-
-		var (
-			newsid = rand.Int63()
-			oldsid = span.SpanId
-		)
+	for i, span := range batch.Spans {
+		olds[i] = [2]int64{span.TraceIdHigh, span.TraceIdLow}
+		newtid, ok := tids[olds[i]]
+		if !ok {
+			newtid = [2]int64{rand.Int63(), rand.Int63()}
+			tids[olds[i]] = newtid
+		}
+		newsid := rand.Int63()
+		sids[[3]int64{olds[i][0], olds[i][1], span.SpanId}] = newsid
+		span.TraceIdHigh, span.TraceIdLow = newtid[0], newtid[1]
 		span.SpanId = newsid
-		for _, s := range batch.Spans {
-			if s.ParentSpanId == oldsid {
-				span.ParentSpanId = newsid
-				break
-			}
+	}
+	// spans are not ordered in batch, relink parents after all IDs changed
+	for i, span := range batch.Spans {
+		if newsid, ok := sids[[3]int64{olds[i][0], olds[i][1], span.ParentSpanId}]; ok {
+			span.ParentSpanId = newsid
 		}
 	}
 }
 
-func newJgAmplifier(endpointAddress string, expectedSpansCount, threads, repeat int) *jgAmplifier {
-	return &jgAmplifier{
-		GeneralAmplifier:   NewGeneralAmplifier("jaeger", threads, repeat, jgAmplifierThread),
+func newJgAmplifier(endpointAddress string, expectedSpansCount, threads, repeat int, opts ...AmplifierOption) *jgAmplifier {
+	jgamp := &jgAmplifier{
 		expectedSpansCount: expectedSpansCount,
 		ready:              make(chan any),
 	}
+	jgamp.GeneralAmplifier = NewGeneralAmplifier("jaeger", threads, repeat, jgamp.amplifierThread, opts...)
+	jgamp.capture.expect(expectedSpansCount)
+
+	return jgamp
 }
 
-func StartJgAgent(agentAddress, endpointAddress string, expectedSpansCount, threads, repeat int) (context.CancelFunc, chan struct{}, error) {
-	ctx, canceler := context.WithCancel(context.TODO())
+// newJgReqFromArchive merges archived jaeger requests into one batch
+func newJgReqFromArchive(records []*ArchiveRecord) (*jgReqWrapper, error) {
+	jgreq := &jgReqWrapper{}
+	for _, rec := range records {
+		if _, ok := jgPatternVersion[rec.Pattern]; !ok {
+			return nil, comerr.ErrUnrecognizedParameters(rec.Pattern)
+		}
+		batch, err := decodeJgBinaryProtocol(bytes.NewReader(rec.Body))
+		if err != nil {
+			return nil, err
+		}
+		jgreq.header = dkhttp.MergeHeaders(jgreq.header, rec.Header)
+		if jgreq.batch == nil {
+			jgreq.batch = batch
+		} else {
+			jgreq.batch.Spans = append(jgreq.batch.Spans, batch.Spans...)
+		}
+	}
+	if jgreq.batch == nil || len(jgreq.batch.Spans) == 0 {
+		return nil, comerr.ErrEmptyValue
+	}
+
+	return jgreq, nil
+}
+
+func StartJgAgent(ctx context.Context, agentAddress, endpointAddress string, expectedSpansCount, threads, repeat int, opts ...AmplifierOption) (context.CancelFunc, chan struct{}, error) {
+	ctx, canceler := context.WithCancel(ctx)
 
-	ampf := newJgAmplifier(endpointAddress, expectedSpansCount, threads, repeat)
+	ampf := newJgAmplifier(endpointAddress, expectedSpansCount, threads, repeat, opts...)
 	finish, err := ampf.StartThreads(ctx, endpointAddress)
 	if err != nil {
+		canceler()
+
 		return nil, nil, err
 	}
 
 	agent := newJgAgent(ampf)
-	agent.Start(agentAddress)
+	if err = agent.Start(agentAddress); err != nil {
+		canceler()
+
+		return nil, nil, err
+	}
+	// capture server is not needed any more once amplifier exits
+	go func() {
+		<-ampf.exit
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := agent.Shutdown(sctx); err != nil {
+			log.Println(err.Error())
+		}
+	}()
 
 	return canceler, finish, nil
 }
@@ -0,0 +1,93 @@
+/*
+*   Copyright (c) 2023 CodapeWild
+*   All rights reserved.
+
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+
+*   http://www.apache.org/licenses/LICENSE-2.0
+
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+ */
+package agent
+
+import (
+	"testing"
+
+	"github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+)
+
+// newTestJgBatch returns two traces sharing the low half of trace ID, spans interleaved
+func newTestJgBatch() *jaeger.Batch {
+	return &jaeger.Batch{
+		Process: &jaeger.Process{ServiceName: "login"},
+		Spans: []*jaeger.Span{
+			{TraceIdHigh: 1, TraceIdLow: 7, SpanId: 12, ParentSpanId: 11, OperationName: "child"},
+			{TraceIdHigh: 2, TraceIdLow: 7, SpanId: 21, OperationName: "root"},
+			{TraceIdHigh: 1, TraceIdLow: 7, SpanId: 11, OperationName: "root"},
+			{TraceIdHigh: 1, TraceIdLow: 7, SpanId: 13, ParentSpanId: 11, OperationName: "child"},
+		},
+	}
+}
+
+func TestBatchJgSpans(t *testing.T) {
+	batch := newTestJgBatch()
+	cases := []struct {
+		by            string
+		size          int
+		traces, spans int
+	}{
+		{by: "", size: 0, traces: 2, spans: 4},
+		{by: BatchByTraces, size: 5, traces: 5, spans: 3 + 1 + 3 + 1 + 3},
+		{by: BatchBySpans, size: 4, traces: 2, spans: 4},
+		{by: BatchBySpans, size: 5, traces: 3, spans: 7},
+		{by: BatchByBytes, size: 1, traces: 1, spans: 3},
+	}
+	for _, c := range cases {
+		payload := batchJgSpans(batch, c.by, c.size)
+		tids := make(map[[2]int64]int)
+		for _, span := range payload.Spans {
+			tids[[2]int64{span.TraceIdHigh, span.TraceIdLow}]++
+		}
+		if len(tids) != c.traces || len(payload.Spans) != c.spans {
+			t.Fatalf("batch by %q size %d: expect %d traces %d spans got %d traces %d spans", c.by, c.size, c.traces, c.spans, len(tids), len(payload.Spans))
+		}
+		if payload.Process != batch.Process {
+			t.Fatalf("batch by %q size %d: process not kept", c.by, c.size)
+		}
+	}
+	// captured spans are never changed by batching
+	if s := batch.Spans[0]; s.TraceIdHigh != 1 || s.TraceIdLow != 7 || s.SpanId != 12 {
+		t.Fatalf("captured span changed: %v", s)
+	}
+}
+
+func TestChangeJgTraceIDs(t *testing.T) {
+	batch := newTestJgBatch()
+	changeJgTraceIDs(batch)
+
+	var (
+		root  = batch.Spans[2]
+		other = batch.Spans[1]
+	)
+	if root.SpanId == 11 || root.ParentSpanId != 0 || other.ParentSpanId != 0 {
+		t.Fatalf("root spans not changed properly: %v %v", root, other)
+	}
+	if root.TraceIdHigh == 1 || root.TraceIdLow == 7 || other.TraceIdHigh == 2 || other.TraceIdLow == 7 {
+		t.Fatalf("trace ID halves not changed: %v %v", root, other)
+	}
+	if root.TraceIdHigh == other.TraceIdHigh && root.TraceIdLow == other.TraceIdLow {
+		t.Fatal("traces differing by high half of trace ID merged")
+	}
+	for _, i := range []int{0, 3} {
+		span := batch.Spans[i]
+		if span.ParentSpanId != root.SpanId || span.TraceIdHigh != root.TraceIdHigh || span.TraceIdLow != root.TraceIdLow {
+			t.Fatalf("child span not relinked: %v", span)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ProtocolOTLP payloads are forwarded only, they can not be replayed so they are not archived
+const ProtocolOTLP = "otlp"
+
+var otlpPatternVersion = map[string]string{
+	"/v1/traces": "v1",
+}
+
+// Proxy forwards trace requests unchanged to upstream collector and saves a copy of
+// every ddtrace and jaeger request carrying traces into the archive of its protocol.
+type Proxy struct {
+	http.ServeMux
+	rp       *httputil.ReverseProxy
+	archives map[string]*ArchiveWriter
+	servers  []*http.Server
+	sync.Mutex
+}
+
+// NewProxy creates proxy towards upstream base URL, archives maps protocol to archive and
+// requests of protocols without archive are forwarded only.
+func NewProxy(upstream string, archives map[string]*ArchiveWriter, tlsConf *tls.Config) (*Proxy, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream address: %s", upstream)
+	}
+
+	p := &Proxy{
+		rp:       httputil.NewSingleHostReverseProxy(u),
+		archives: archives,
+	}
+	p.rp.Transport = newSingleHostTransport(tlsConf, nil)
+	director := p.rp.Director
+	p.rp.Director = func(req *http.Request) {
+		director(req)
+		// a nil value keeps ReverseProxy from adding X-Forwarded-For, upstream sees the headers of application only
+		req.Header["X-Forwarded-For"] = nil
+	}
+	for pattern, version := range ddPatternVersion {
+		p.HandleFunc(pattern, p.handle(ProtocolDDTrace, pattern, version))
+	}
+	for pattern, version := range jgPatternVersion {
+		p.HandleFunc(pattern, p.handle(ProtocolJaeger, pattern, version))
+	}
+	for pattern, version := range otlpPatternVersion {
+		p.HandleFunc(pattern, p.handle(ProtocolOTLP, pattern, version))
+	}
+
+	return p, nil
+}
+
+// Start listens on addr and serves in background, the error of listening is returned
+func (p *Proxy) Start(addr string) error {
+	ln, err := listen(addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Addr: addr, Handler: p}
+	p.Lock()
+	p.servers = append(p.servers, srv)
+	p.Unlock()
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Println(err.Error())
+		}
+	}()
+
+	return nil
+}
+
+// Close stops all listeners, archives are left to their owner
+func (p *Proxy) Close() {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, srv := range p.servers {
+		srv.Close()
+	}
+}
+
+func (p *Proxy) handle(protocol, pattern, version string) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		raw, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			log.Println(err.Error())
+			resp.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(raw))
+		p.archive(protocol, pattern, version, req.Header.Clone(), raw)
+		p.rp.ServeHTTP(resp, req)
+	}
+}
+
+// archive decodes a copy of request, payloads that can not be replayed are not archived
+func (p *Proxy) archive(protocol, pattern, version string, header http.Header, raw []byte) {
+	archive, ok := p.archives[protocol]
+	if !ok {
+		return
+	}
+
+	dupli := &http.Request{Header: header, Body: io.NopCloser(bytes.NewReader(raw))}
+	if err := decompressBody(dupli); err != nil {
+		log.Println(err.Error())
+
+		return
+	}
+	body, err := io.ReadAll(dupli.Body)
+	if err != nil {
+		log.Println(err.Error())
+
+		return
+	}
+	dupli.Body = io.NopCloser(bytes.NewReader(body))
+
+	var spans int
+	switch protocol {
+	case ProtocolDDTrace:
+		if countTraces(dupli) == 0 {
+			return
+		}
+		traces, err := decodeDDTraces(version, dupli)
+		if err != nil {
+			log.Println(err.Error())
+
+			return
+		}
+		for _, trace := range traces {
+			spans += len(trace)
+		}
+	case ProtocolJaeger:
+		batch, err := decodeJgBinaryProtocol(dupli.Body)
+		if err != nil {
+			log.Println(err.Error())
+
+			return
+		}
+		spans = len(batch.Spans)
+	default:
+		return
+	}
+	if spans == 0 {
+		return
+	}
+
+	if err := archive.Write(&ArchiveRecord{Protocol: protocol, Pattern: pattern, Header: dupli.Header, Body: body, Time: time.Now()}); err != nil {
+		log.Println(err.Error())
+	} else {
+		log.Printf("%s: archived %s with %d spans", protocol, pattern, spans)
+	}
+}
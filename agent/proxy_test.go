@@ -0,0 +1,181 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	body, err := newTestDDTraces().MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	gzipped, err := compress(CompressGzip, body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		got, _ := io.ReadAll(req.Body)
+		if !bytes.Equal(got, gzipped) || req.Header.Get("Content-Encoding") != CompressGzip {
+			t.Error("request not forwarded unchanged")
+		}
+		resp.WriteHeader(http.StatusAccepted)
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "proxy-ddtrace.jsonl")
+	archive, err := NewArchiveWriter(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	proxy, err := NewProxy(upstream.URL, map[string]*ArchiveWriter{ProtocolDDTrace: archive}, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	srv := httptest.NewServer(proxy)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v0.4/traces", bytes.NewReader(gzipped))
+	req.Header.Set("Content-Type", "application/msgpack")
+	req.Header.Set("Content-Encoding", CompressGzip)
+	req.Header.Set("X-Datadog-Trace-Count", "2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("upstream status not returned: %s", resp.Status)
+	}
+	archive.Close()
+
+	records, err := LoadArchive(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(records) != 1 || !bytes.Equal(records[0].Body, body) || records[0].Header.Get("Content-Encoding") != "" {
+		t.Fatalf("unexpected archive: %v", records)
+	}
+}
+
+func TestProxyHeaders(t *testing.T) {
+	var received http.Header
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		received = req.Header.Clone()
+		resp.WriteHeader(http.StatusAccepted)
+	}))
+	defer upstream.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	tlsConf, err := NewClientTLSConfig(caFile, "", "", "example.com", false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	proxy, err := NewProxy(upstream.URL, nil, tlsConf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// headers are recorded as the proxy receives them, including the ones added by client
+	var sent http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		sent = req.Header.Clone()
+		proxy.ServeHTTP(resp, req)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/v0.4/traces", bytes.NewReader([]byte("traces")))
+	req.Header.Set("Content-Type", "application/msgpack")
+	req.Header.Set("Datadog-Meta-Lang", "go")
+	req.Header.Set("X-Datadog-Trace-Count", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("upstream status not returned: %s", resp.Status)
+	}
+	if !reflect.DeepEqual(received, sent) {
+		t.Fatalf("expect upstream headers %v got %v", sent, received)
+	}
+}
+
+func TestProxyStart(t *testing.T) {
+	proxy, err := NewProxy("http://127.0.0.1:9529", nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer proxy.Close()
+
+	if err = proxy.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = proxy.Start("127.0.0.1:-1"); err == nil {
+		t.Fatal("expect error listening on invalid address")
+	}
+}
+
+func TestProxyOTLPNotArchived(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "proxy-otlp.jsonl")
+	archive, err := NewArchiveWriter(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	proxy, err := NewProxy(upstream.URL, map[string]*ArchiveWriter{ProtocolOTLP: archive}, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	srv := httptest.NewServer(proxy)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/v1/traces", "application/x-protobuf", bytes.NewReader([]byte("spans")))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upstream status not returned: %s", resp.Status)
+	}
+	archive.Close()
+
+	records, err := LoadArchive(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(records) != 0 {
+		t.Fatalf("expect otlp not archived got %d records", len(records))
+	}
+}
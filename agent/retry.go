@@ -0,0 +1,217 @@
+/*
+*   Copyright (c) 2023 CodapeWild
+*   All rights reserved.
+
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+
+*   http://www.apache.org/licenses/LICENSE-2.0
+
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+ */
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defRetryOn are statuses retried unless RetryOptions.RetryOn is set
+var defRetryOn = []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}
+
+// maxRetryDelay bounds the delay before a retry unless RetryOptions.MaxBackoff is set
+const maxRetryDelay = time.Minute
+
+// RetryOptions emulates tracers retrying payloads rejected by collector, transport errors
+// and statuses of RetryOn are retried with exponential backoff until MaxAttempts.
+type RetryOptions struct {
+	// attempts of a payload including the first one, dropped after the last one
+	MaxAttempts int
+	// delay before the first retry, doubled by every retry up to MaxBackoff, one minute
+	// if not set, which bounds Retry-After as well
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// statuses retried, 429 and 503 if empty
+	RetryOn []int
+	// wait as long as Retry-After of response instead of backoff
+	RetryAfter bool
+}
+
+// WithRetry retries payloads failed as configured, see RetryOptions.
+func WithRetry(retry *RetryOptions) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.retry = retry
+	}
+}
+
+// WithQueue makes every thread put payloads into a queue of size every interval, sent one
+// by one from the queue, payloads are dropped while the queue is full. Size and interval
+// must be positive, see CheckQueue.
+func WithQueue(size int, interval time.Duration) AmplifierOption {
+	return func(gamp *GeneralAmplifier) {
+		gamp.queueSize = size
+		gamp.queueInterval = interval
+	}
+}
+
+// CheckRetry checks retry options, nil means no retry
+func CheckRetry(retry *RetryOptions) error {
+	if retry == nil {
+		return nil
+	}
+	if retry.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be positive: %d", retry.MaxAttempts)
+	}
+	if retry.Backoff < 0 || retry.MaxBackoff < 0 {
+		return fmt.Errorf("negative backoff: %s max: %s", retry.Backoff, retry.MaxBackoff)
+	}
+
+	return nil
+}
+
+// CheckQueue checks queue options of WithQueue, payloads put without interval would be built
+// faster than sent and dropped but for the first size of them
+func CheckQueue(size int, interval time.Duration) error {
+	if size <= 0 {
+		return fmt.Errorf("queue size must be positive: %d", size)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("queue interval must be positive: %s", interval)
+	}
+
+	return nil
+}
+
+// retryable tells whether a payload answered by resp, nil on transport error, is retried
+func (retry *RetryOptions) retryable(resp *http.Response) bool {
+	if resp == nil {
+		return true
+	}
+	retryOn := retry.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = defRetryOn
+	}
+	for _, status := range retryOn {
+		if resp.StatusCode == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// delay returns the wait before retry, counting from 1, of a payload answered by resp,
+// bounded by MaxBackoff or maxRetryDelay
+func (retry *RetryOptions) delay(attempt int, resp *http.Response) time.Duration {
+	ceiling := retry.MaxBackoff
+	if ceiling <= 0 {
+		ceiling = maxRetryDelay
+	}
+
+	if retry.RetryAfter && resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if d > ceiling {
+				d = ceiling
+			}
+
+			return d
+		}
+	}
+
+	// stop doubling once past the ceiling, so that many attempts never overflow
+	d := retry.Backoff
+	for i := 1; i < attempt && d < ceiling; i++ {
+		d *= 2
+	}
+	if d > ceiling {
+		d = ceiling
+	}
+
+	return d
+}
+
+// parseRetryAfter parses Retry-After in seconds or as HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+
+		return 0, true
+	}
+
+	return 0, false
+}
+
+type queuedPayload struct {
+	seq  int
+	body []byte
+}
+
+// sendAll sends repeat payloads of spans built by next, through the queue if configured
+func (gamp *GeneralAmplifier) sendAll(ID int, ctx context.Context, client *http.Client, endpoint string, header http.Header, repeat, spans int, next func() ([]byte, error)) {
+	if gamp.queueSize <= 0 {
+		for i := 1; i <= repeat; i++ {
+			if ctx.Err() != nil {
+				log.Printf("thread %d canceled after %d times", ID, i-1)
+				break
+			}
+			if body, err := next(); err != nil {
+				log.Println(err.Error())
+			} else {
+				gamp.send(ctx, ID, i, client, endpoint, header, body, spans)
+			}
+		}
+
+		return
+	}
+
+	queue := make(chan *queuedPayload, gamp.queueSize)
+	go func() {
+		defer close(queue)
+
+		for i := 1; i <= repeat; i++ {
+			if i > 1 && gamp.queueInterval > 0 {
+				select {
+				case <-time.After(gamp.queueInterval):
+				case <-ctx.Done():
+				}
+			}
+			if ctx.Err() != nil {
+				log.Printf("thread %d canceled after %d times queued", ID, i-1)
+				return
+			}
+			body, err := next()
+			if err != nil {
+				log.Println(err.Error())
+				continue
+			}
+			select {
+			case queue <- &queuedPayload{seq: i, body: body}:
+			default:
+				gamp.stats.queueDrop(spans)
+				log.Printf("thread %d queue full, payload %d dropped", ID, i)
+			}
+		}
+	}()
+	for p := range queue {
+		if ctx.Err() == nil {
+			gamp.send(ctx, ID, p.seq, client, endpoint, header, p.body, spans)
+		}
+	}
+}
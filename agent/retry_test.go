@@ -0,0 +1,166 @@
+/*
+*   Copyright (c) 2023 CodapeWild
+*   All rights reserved.
+
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+
+*   http://www.apache.org/licenses/LICENSE-2.0
+
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+ */
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	retry := &RetryOptions{MaxAttempts: 5, Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond, RetryAfter: true}
+	for attempt, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond} {
+		if d := retry.delay(attempt+1, nil); d != expected {
+			t.Fatalf("attempt %d: expect backoff %s got %s", attempt+1, expected, d)
+		}
+	}
+
+	// Retry-After is bounded by MaxBackoff, or one minute without it
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"2"}}}
+	if d := retry.delay(1, resp); d != 300*time.Millisecond {
+		t.Fatalf("Retry-After not bounded by max backoff: %s", d)
+	}
+	unbounded := &RetryOptions{MaxAttempts: 5, Backoff: 100 * time.Millisecond, RetryAfter: true}
+	if d := unbounded.delay(1, resp); d != 2*time.Second {
+		t.Fatalf("Retry-After in seconds not honoured: %s", d)
+	}
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if d := unbounded.delay(1, resp); d != maxRetryDelay {
+		t.Fatalf("Retry-After as date not bounded: %s", d)
+	}
+	if d := unbounded.delay(100, nil); d != maxRetryDelay {
+		t.Fatalf("backoff of many attempts not bounded: %s", d)
+	}
+	resp.Header.Set("Retry-After", "soon")
+	if d := retry.delay(1, resp); d != 100*time.Millisecond {
+		t.Fatalf("invalid Retry-After not ignored: %s", d)
+	}
+
+	if !retry.retryable(nil) || !retry.retryable(&http.Response{StatusCode: http.StatusServiceUnavailable}) || retry.retryable(&http.Response{StatusCode: http.StatusBadRequest}) {
+		t.Fatal("unexpected retryable statuses")
+	}
+	if err := CheckRetry(&RetryOptions{}); err == nil {
+		t.Fatal("expect error for retry without attempts")
+	}
+	for _, c := range []struct {
+		size     int
+		interval time.Duration
+		ok       bool
+	}{
+		{size: 10, interval: time.Millisecond, ok: true},
+		{size: 0, interval: time.Millisecond},
+		{size: 10, interval: 0},
+		{size: 10, interval: -time.Millisecond},
+	} {
+		if err := CheckQueue(c.size, c.interval); (err == nil) != c.ok {
+			t.Fatalf("unexpected check result of queue size: %d interval: %s: %v", c.size, c.interval, err)
+		}
+	}
+}
+
+func TestReplayRetry(t *testing.T) {
+	body, err := newTestDDTraces().MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	records := []*ArchiveRecord{{Protocol: ProtocolDDTrace, Pattern: "/v0.4/traces", Body: body, Header: http.Header{"Content-Type": []string{"application/msgpack"}}}}
+	for name, c := range map[string]struct {
+		status func(n int32) int
+		retry  *RetryOptions
+		st     *Stats
+	}{
+		"recovered": {
+			// collector overloaded for the first two requests
+			status: func(n int32) int {
+				if n <= 2 {
+					return http.StatusServiceUnavailable
+				}
+
+				return http.StatusOK
+			},
+			retry: &RetryOptions{MaxAttempts: 3, Backoff: time.Millisecond},
+			st:    &Stats{Requests: 7, Errors: 2, Retries: 2},
+		},
+		"dropped": {
+			status: func(int32) int { return http.StatusTooManyRequests },
+			retry:  &RetryOptions{MaxAttempts: 2, Backoff: time.Hour, RetryAfter: true},
+			st:     &Stats{Requests: 10, Errors: 10, Retries: 5, Dropped: 5, DroppedSpans: 20},
+		},
+		"not retried": {
+			status: func(int32) int { return http.StatusBadRequest },
+			retry:  &RetryOptions{MaxAttempts: 3, Backoff: time.Millisecond},
+			st:     &Stats{Requests: 5, Errors: 5},
+		},
+	} {
+		var received int32
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.Header().Set("Retry-After", "0")
+			resp.WriteHeader(c.status(atomic.AddInt32(&received, 1)))
+		}))
+		stats := NewStats()
+		canceler, finish, err := StartReplay(context.TODO(), records, srv.URL+"/v0.4/traces", 1, 5, WithStats(stats), WithRetry(c.retry))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		select {
+		case <-finish:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: replay timeout", name)
+		}
+		canceler()
+		srv.Close()
+
+		st := stats.Snapshot()
+		if st.Requests != c.st.Requests || st.Errors != c.st.Errors || st.Retries != c.st.Retries || st.Dropped != c.st.Dropped || st.DroppedSpans != c.st.DroppedSpans {
+			t.Fatalf("%s: unexpected stats: %+v", name, st)
+		}
+	}
+}
+
+func TestReplayQueue(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	body, err := newTestDDTraces().MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	records := []*ArchiveRecord{{Protocol: ProtocolDDTrace, Pattern: "/v0.4/traces", Body: body, Header: http.Header{"Content-Type": []string{"application/msgpack"}}}}
+	stats := NewStats()
+	canceler, finish, err := StartReplay(context.TODO(), records, srv.URL+"/v0.4/traces", 1, 10, WithStats(stats), WithQueue(2, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer canceler()
+
+	select {
+	case <-finish:
+	case <-time.After(10 * time.Second):
+		t.Fatal("replay timeout")
+	}
+	st := stats.Snapshot()
+	if st.QueueDropped == 0 || st.Requests+st.QueueDropped != 10 || st.DroppedSpans != 4*st.QueueDropped {
+		t.Fatalf("unexpected stats of queue: %+v", st)
+	}
+}
@@ -0,0 +1,302 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// classes of failed requests
+const (
+	ErrClassTransport = "transport"
+	ErrClass4xx       = "4xx"
+	ErrClass5xx       = "5xx"
+	ErrClassOther     = "other"
+)
+
+// LatencyBuckets are upper bounds in seconds of request latency histogram
+var LatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into LatencyBuckets, Counts has one more bucket for +Inf
+// and is not cumulative.
+type Histogram struct {
+	Counts []int64 `json:"counts"`
+	Count  int64   `json:"count"`
+	// sum of observations in nanoseconds
+	SumNanos int64 `json:"sum_nanos"`
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{Counts: make([]int64, len(LatencyBuckets)+1)}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for ; i < len(LatencyBuckets); i++ {
+		if d.Seconds() <= LatencyBuckets[i] {
+			break
+		}
+	}
+	atomic.AddInt64(&h.Counts[i], 1)
+	atomic.AddInt64(&h.Count, 1)
+	atomic.AddInt64(&h.SumNanos, int64(d))
+}
+
+func (h *Histogram) Snapshot() *Histogram {
+	dupli := NewHistogram()
+	for i := range h.Counts {
+		dupli.Counts[i] = atomic.LoadInt64(&h.Counts[i])
+	}
+	dupli.Count = atomic.LoadInt64(&h.Count)
+	dupli.SumNanos = atomic.LoadInt64(&h.SumNanos)
+
+	return dupli
+}
+
+// Merge adds observations of other into h, both must share LatencyBuckets
+func (h *Histogram) Merge(other *Histogram) {
+	for i := range h.Counts {
+		if i < len(other.Counts) {
+			atomic.AddInt64(&h.Counts[i], other.Counts[i])
+		}
+	}
+	atomic.AddInt64(&h.Count, other.Count)
+	atomic.AddInt64(&h.SumNanos, other.SumNanos)
+}
+
+// Quantile estimates the q-quantile by linear interpolation inside the bucket it falls in,
+// observations above the last bound are reported as the last bound.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	var (
+		rank       = q * float64(h.Count)
+		cumulative int64
+	)
+	for i, c := range h.Counts {
+		if c == 0 || float64(cumulative+c) < rank {
+			cumulative += c
+			continue
+		}
+		if i == len(LatencyBuckets) {
+			break
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = LatencyBuckets[i-1]
+		}
+		upper := LatencyBuckets[i]
+		seconds := lower + (upper-lower)*(rank-float64(cumulative))/float64(c)
+
+		return time.Duration(seconds * float64(time.Second))
+	}
+
+	return time.Duration(LatencyBuckets[len(LatencyBuckets)-1] * float64(time.Second))
+}
+
+// Stats counts traffic sent by amplifier threads, it's safe for concurrent use.
+// Requests failed by transport errors or non-2xx status are counted in Errors as well.
+type Stats struct {
+	Requests int64            `json:"requests"`
+	Spans    int64            `json:"spans"`
+	Bytes    int64            `json:"bytes"`
+	Errors   int64            `json:"errors"`
+	InFlight int64            `json:"in_flight"`
+	ErrorsBy map[string]int64 `json:"errors_by_class,omitempty"`
+	Latency  *Histogram       `json:"latency"`
+	// requests planned and sent by each thread
+	Planned int64   `json:"planned"`
+	Threads []int64 `json:"threads,omitempty"`
+	// retries of payloads, counted in Requests as well, and payloads dropped after the
+	// last attempt or while the send queue is full, with their spans
+	Retries      int64 `json:"retries,omitempty"`
+	Dropped      int64 `json:"dropped,omitempty"`
+	DroppedSpans int64 `json:"dropped_spans,omitempty"`
+	QueueDropped int64 `json:"queue_dropped,omitempty"`
+	// connections dialed or reused by requests
+	Dials  int64 `json:"dials"`
+	Reuses int64 `json:"reuses"`
+	// warm-up requests and their spans, not counted in any other field
+	Warmup      int64 `json:"warmup,omitempty"`
+	WarmupSpans int64 `json:"warmup_spans,omitempty"`
+
+	errorsBy [4]int64
+	mu       sync.RWMutex
+}
+
+var errClasses = [4]string{ErrClassTransport, ErrClass4xx, ErrClass5xx, ErrClassOther}
+
+func NewStats() *Stats {
+	return &Stats{Latency: NewHistogram()}
+}
+
+// plan resets per thread progress before threads start
+func (st *Stats) plan(threads, repeat int) {
+	if st == nil {
+		return
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.Threads = make([]int64, threads)
+	atomic.StoreInt64(&st.Planned, int64(threads*repeat))
+}
+
+func (st *Stats) begin() {
+	if st != nil {
+		atomic.AddInt64(&st.InFlight, 1)
+	}
+}
+
+// warmedUp counts warm-up requests sent by a thread
+func (st *Stats) warmedUp(requests, spans int) {
+	if st != nil {
+		atomic.AddInt64(&st.Warmup, int64(requests))
+		atomic.AddInt64(&st.WarmupSpans, int64(spans))
+	}
+}
+
+// retried counts one payload sent again
+func (st *Stats) retried() {
+	if st != nil {
+		atomic.AddInt64(&st.Retries, 1)
+	}
+}
+
+// drop counts one payload given up after its last attempt
+func (st *Stats) drop(spans int) {
+	if st != nil {
+		atomic.AddInt64(&st.Dropped, 1)
+		atomic.AddInt64(&st.DroppedSpans, int64(spans))
+	}
+}
+
+// queueDrop counts one payload dropped while the send queue is full
+func (st *Stats) queueDrop(spans int) {
+	if st != nil {
+		atomic.AddInt64(&st.QueueDropped, 1)
+		atomic.AddInt64(&st.DroppedSpans, int64(spans))
+	}
+}
+
+// end counts one request sent by thread ID, resp is nil on transport error
+func (st *Stats) end(ID, spans, bytes int, resp *http.Response, latency time.Duration) {
+	if st == nil {
+		return
+	}
+
+	atomic.AddInt64(&st.InFlight, -1)
+	atomic.AddInt64(&st.Requests, 1)
+	atomic.AddInt64(&st.Spans, int64(spans))
+	atomic.AddInt64(&st.Bytes, int64(bytes))
+	st.Latency.Observe(latency)
+
+	class := -1
+	switch {
+	case resp == nil:
+		class = 0
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499:
+		class = 1
+	case resp.StatusCode >= 500 && resp.StatusCode <= 599:
+		class = 2
+	default:
+		class = 3
+	}
+	if class >= 0 {
+		atomic.AddInt64(&st.Errors, 1)
+		atomic.AddInt64(&st.errorsBy[class], 1)
+	}
+
+	st.mu.RLock()
+	if ID >= 1 && ID <= len(st.Threads) {
+		atomic.AddInt64(&st.Threads[ID-1], 1)
+	}
+	st.mu.RUnlock()
+}
+
+// Snapshot returns a copy of counters for reporting
+func (st *Stats) Snapshot() *Stats {
+	dupli := &Stats{
+		Requests:     atomic.LoadInt64(&st.Requests),
+		Spans:        atomic.LoadInt64(&st.Spans),
+		Bytes:        atomic.LoadInt64(&st.Bytes),
+		Errors:       atomic.LoadInt64(&st.Errors),
+		InFlight:     atomic.LoadInt64(&st.InFlight),
+		ErrorsBy:     make(map[string]int64),
+		Latency:      st.Latency.Snapshot(),
+		Planned:      atomic.LoadInt64(&st.Planned),
+		Retries:      atomic.LoadInt64(&st.Retries),
+		Dropped:      atomic.LoadInt64(&st.Dropped),
+		DroppedSpans: atomic.LoadInt64(&st.DroppedSpans),
+		QueueDropped: atomic.LoadInt64(&st.QueueDropped),
+		Dials:        atomic.LoadInt64(&st.Dials),
+		Reuses:       atomic.LoadInt64(&st.Reuses),
+		Warmup:       atomic.LoadInt64(&st.Warmup),
+		WarmupSpans:  atomic.LoadInt64(&st.WarmupSpans),
+	}
+	for i, class := range errClasses {
+		if c := atomic.LoadInt64(&st.errorsBy[i]); c != 0 {
+			dupli.ErrorsBy[class] = c
+		}
+	}
+
+	st.mu.RLock()
+	dupli.Threads = make([]int64, len(st.Threads))
+	for i := range st.Threads {
+		dupli.Threads[i] = atomic.LoadInt64(&st.Threads[i])
+	}
+	st.mu.RUnlock()
+
+	return dupli
+}
+
+// Merge adds counters of other into st, used to combine stats reported by several
+// amplifiers. Threads of other are appended after threads of st.
+func (st *Stats) Merge(other *Stats) {
+	atomic.AddInt64(&st.Requests, other.Requests)
+	atomic.AddInt64(&st.Spans, other.Spans)
+	atomic.AddInt64(&st.Bytes, other.Bytes)
+	atomic.AddInt64(&st.Errors, other.Errors)
+	atomic.AddInt64(&st.InFlight, other.InFlight)
+	atomic.AddInt64(&st.Planned, other.Planned)
+	atomic.AddInt64(&st.Retries, other.Retries)
+	atomic.AddInt64(&st.Dropped, other.Dropped)
+	atomic.AddInt64(&st.DroppedSpans, other.DroppedSpans)
+	atomic.AddInt64(&st.QueueDropped, other.QueueDropped)
+	atomic.AddInt64(&st.Dials, other.Dials)
+	atomic.AddInt64(&st.Reuses, other.Reuses)
+	atomic.AddInt64(&st.Warmup, other.Warmup)
+	atomic.AddInt64(&st.WarmupSpans, other.WarmupSpans)
+	for i, class := range errClasses {
+		atomic.AddInt64(&st.errorsBy[i], other.ErrorsBy[class])
+	}
+	if other.Latency != nil {
+		st.Latency.Merge(other.Latency)
+	}
+
+	st.mu.Lock()
+	st.Threads = append(st.Threads, other.Threads...)
+	st.mu.Unlock()
+}
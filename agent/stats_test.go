@@ -0,0 +1,66 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram()
+	if h.Quantile(0.5) != 0 {
+		t.Fatal("expect zero quantile of empty histogram")
+	}
+
+	// 100 observations evenly inside (10ms, 25ms]
+	for i := 0; i < 100; i++ {
+		h.Observe(20 * time.Millisecond)
+	}
+	if q := h.Quantile(0.5); q != 17500*time.Microsecond {
+		t.Fatalf("unexpected p50: %s", q)
+	}
+
+	h.Observe(time.Minute)
+	if q := h.Quantile(1); q != 10*time.Second {
+		t.Fatalf("observation above last bound not capped: %s", q)
+	}
+}
+
+func TestStatsMerge(t *testing.T) {
+	a, b := NewStats(), NewStats()
+	a.plan(2, 3)
+	b.plan(1, 3)
+	a.end(1, 5, 100, &http.Response{StatusCode: http.StatusOK}, 2*time.Millisecond)
+	b.end(1, 5, 100, &http.Response{StatusCode: http.StatusServiceUnavailable}, 2*time.Second)
+
+	merged := NewStats()
+	merged.Merge(a.Snapshot())
+	merged.Merge(b.Snapshot())
+	st := merged.Snapshot()
+	if st.Requests != 2 || st.Spans != 10 || st.Planned != 9 || st.Errors != 1 || st.ErrorsBy[ErrClass5xx] != 1 {
+		t.Fatalf("unexpected merged stats: %+v", st)
+	}
+	if len(st.Threads) != 3 || st.Threads[0] != 1 || st.Threads[2] != 1 {
+		t.Fatalf("unexpected merged threads: %v", st.Threads)
+	}
+	if st.Latency.Count != 2 || st.Latency.Quantile(1) != 2500*time.Millisecond {
+		t.Fatalf("unexpected merged latency: %+v", st.Latency)
+	}
+}
@@ -18,11 +18,13 @@
 package agent
 
 import (
+	"errors"
 	"log"
 	"mime"
 	"net"
 	"net/http"
-	"time"
+	"net/url"
+	"strings"
 )
 
 func getMetaType(req *http.Request, def string) string {
@@ -36,17 +38,64 @@ func getMetaType(req *http.Request, def string) string {
 	return mt
 }
 
-func newSingleHostTransport() *http.Transport {
-	return &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-		MaxIdleConnsPerHost:   100,
-		MaxConnsPerHost:       100,
-		IdleConnTimeout:       10 * time.Second,
-		ResponseHeaderTimeout: 10 * time.Second,
-		ExpectContinueTimeout: time.Second,
-		WriteBufferSize:       10 * 1024,
+// cloneHeader returns a deep copy of h which is never nil
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return make(http.Header)
 	}
+
+	return h.Clone()
+}
+
+// redactURL replaces query values of rawURL which may carry the auth token
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	query := u.Query()
+	for k := range query {
+		query.Set(k, "xxxxx")
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// redactError redacts the request URL reported by err, see redactURL
+func redactError(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return &url.Error{Op: uerr.Op, URL: redactURL(uerr.URL), Err: uerr.Err}
+	}
+
+	return err
+}
+
+// UnixPrefix marks agent addresses of Unix sockets
+const UnixPrefix = "unix://"
+
+// UnixAddress returns the agent address of Unix socket at path, see ParseUnixAddress
+func UnixAddress(path string) string {
+	return UnixPrefix + path
+}
+
+// ParseUnixAddress returns the socket path of addr made by UnixAddress, ok is false for
+// TCP addresses
+func ParseUnixAddress(addr string) (path string, ok bool) {
+	if !strings.HasPrefix(addr, UnixPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(addr, UnixPrefix), true
+}
+
+// listen listens on a TCP address or a Unix socket made by UnixAddress, the socket file
+// is removed once the listener is closed
+func listen(addr string) (net.Listener, error) {
+	if path, ok := ParseUnixAddress(addr); ok {
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", addr)
 }
@@ -0,0 +1,39 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedactError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	endpoint := srv.URL + "/v0.4/traces?token=secret"
+	srv.Close()
+
+	_, err := http.Post(endpoint, "application/msgpack", nil)
+	if err == nil {
+		t.Fatal("expect error sending to closed server")
+	}
+	if err = redactError(err); strings.Contains(err.Error(), "secret") || !strings.Contains(err.Error(), "/v0.4/traces?token=xxxxx") {
+		t.Fatalf("token not redacted: %s", err.Error())
+	}
+}
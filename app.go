@@ -19,112 +19,137 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
-	"math/rand"
-	"os"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+	"github.com/CodapeWild/dktrace-data-benchmark/bench"
 )
 
 func main() {
 	Execute()
-
-	log.SetFlags(log.Lshortfile | log.LstdFlags)
-	log.SetOutput(os.Stdout)
-	if gBenchConf.DisableLog {
-		log.Println("log disabled")
-		log.SetOutput(nil)
-	}
-
-	if gBenchConf == nil || len(gBenchConf.Tasks) == 0 {
-		log.Println("dktrace-data-benchmark not configurated properly")
-
-		return
-	}
-
-	// runTaskThread()
 }
 
 var (
-	gTaskChan = make(chan *taskConfig, 20)
+	gTaskChan = make(chan []*bench.TaskConfig, 20)
 	gCloser   = make(chan struct{})
-	gFinish   = make(chan struct{})
+	gFinish   = make(chan *bench.Result)
+	gMetrics  = bench.NewMetricsRegistry()
+	gRunner   = bench.NewRunner(bench.RunnerWithMetrics(gMetrics))
 )
 
-func runTaskThread() {
+// runTaskThread runs units of tasks planned by bench.PlanTasks one after another, tasks of
+// a unit run together within budget, with a pause of cooldown between units
+func runTaskThread(ctx context.Context, budget *bench.CPUBudget, cooldown time.Duration) {
+	ran := false
 	for {
 		select {
 		case <-gCloser:
 			return
-		case task := <-gTaskChan:
-			var (
-				canceler context.CancelFunc
-				finish   chan struct{}
-				err      error
-			)
-			switch task.Tracer {
-			case dd:
-				canceler, finish, err = benchDDTraceCollector(task)
-			case jg:
-				canceler, finish, err = benchJaegerCollector(task)
-			case otel:
-			case pp:
-			case sky:
-			case zpk:
-			default:
-				log.Printf("unrecognized task, Name: %s Tracer %s\n", task.Name, task.Tracer)
+		case unit := <-gTaskChan:
+			if ran {
+				coolDown(ctx, cooldown)
 			}
-			if err != nil {
-				canceler()
-				log.Println(err.Error())
-				continue
-			}
-			// waiting for the current task to complete and then start the next one multiple
+			ran = true
+			// waiting for the current unit to complete and then start the next one, multiple
 			// threads benchmark task will seriously affect local host performance
-			if finish != nil {
-				<-finish
+			for _, res := range gRunner.RunGroup(ctx, unit, budget) {
+				gFinish <- res
 			}
-			gFinish <- struct{}{}
 		}
 	}
 }
 
-// generate a random port ranging from 6000 to 9000
-func newRandomPortWithLocalHost() string {
-	return fmt.Sprintf("127.0.0.1:%d", rand.Intn(3000)+6000)
+// runUnits feeds units to runTaskThread and returns results of all tasks, units are sent in
+// background while results are received since neither channel holds all of them
+func runUnits(units [][]*bench.TaskConfig, tasks int) []*bench.Result {
+	go func() {
+		for _, unit := range units {
+			gTaskChan <- unit
+		}
+	}()
+
+	results := make([]*bench.Result, 0, tasks)
+	for i := 0; i < tasks; i++ {
+		results = append(results, <-gFinish)
+	}
+
+	return results
 }
 
-func benchDDTraceCollector(taskConf *taskConfig) (canceler context.CancelFunc, finish chan struct{}, err error) {
-	var r route
-	if r, err = newRouteFromJSONFile(taskConf.RouteConfig); err != nil {
-		return
+// parseCooldown parses cooldown of configuration, empty means no pause
+func parseCooldown(cooldown string) (time.Duration, error) {
+	if cooldown == "" {
+		return 0, nil
 	}
 
-	tr := r.createTree(&DDTracerWrapper{})
-	agentAddress := newRandomPortWithLocalHost()
-	canceler, finish, err = agent.StartDDAgent(agentAddress, fmt.Sprintf("http://%s:%d%s", taskConf.CollectorIP, taskConf.CollectorPort, taskConf.CollectorPath), tr.count(), taskConf.SendThreads, taskConf.SendTimesPerThread)
-	if err != nil {
+	return time.ParseDuration(cooldown)
+}
+
+// coolDown pauses for d between tasks, so that the collector settles down before the next
+// one, it returns at once if ctx is done
+func coolDown(ctx context.Context, d time.Duration) {
+	if d <= 0 {
 		return
 	}
-	tr.spawn(context.TODO(), agentAddress)
 
-	return
+	log.Printf("cooling down for %s", d)
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
 }
 
-func benchJaegerCollector(taskConf *taskConfig) (canceler context.CancelFunc, finish chan struct{}, err error) {
-	var r route
-	if r, err = newRouteFromJSONFile(taskConf.RouteConfig); err != nil {
-		return
+// newRunContext is canceled by SIGINT, SIGTERM or after timeout if not empty, a second
+// signal terminates the process as usual
+func newRunContext(timeout string) (context.Context, context.CancelFunc, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if timeout == "" {
+		return ctx, stop, nil
 	}
 
-	tr := r.createTree(&JgTracerWrapper{})
-	agentAddress := newRandomPortWithLocalHost()
-	canceler, finish, err = agent.StartJgAgent(agentAddress, fmt.Sprintf("http://%s:%d%s", taskConf.CollectorIP, taskConf.CollectorPort, taskConf.CollectorPath), tr.count(), taskConf.SendThreads, taskConf.SendTimesPerThread)
+	d, err := time.ParseDuration(timeout)
 	if err != nil {
-		return
+		stop()
+
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+
+	return ctx, func() {
+		cancel()
+		stop()
+	}, nil
+}
+
+// startMetricsServer exposes /metrics on address in background
+func startMetricsServer(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", gMetrics)
+	go func() {
+		log.Printf("metrics exposed on http://%s/metrics", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.Println(err.Error())
+		}
+	}()
+}
+
+// runWorker serves jobs from coordinator on address until the listener fails, coordinator
+// must send token if not empty
+func runWorker(address, token string) error {
+	var opts []bench.WorkerServerOption
+	if token != "" {
+		opts = append(opts, bench.WorkerWithToken(token))
+	} else {
+		log.Println("worker accepts jobs from anyone reaching it, set --token to require a token")
 	}
-	tr.spawn(context.TODO(), agentAddress)
+	log.Printf("worker listening on http://%s", address)
 
-	return
+	return http.ListenAndServe(address, bench.NewWorkerServer(gRunner, opts...))
 }
@@ -0,0 +1,148 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+)
+
+// AuthConfig holds credentials attached to every replayed request, all values
+// support ${ENV_NAME} references to environment variables
+type AuthConfig struct {
+	BearerToken   string `json:"bearer_token,omitempty"`
+	BasicUser     string `json:"basic_user,omitempty"`
+	BasicPassword string `json:"basic_password,omitempty"`
+	APIKeyHeader  string `json:"api_key_header,omitempty"`
+	APIKey        string `json:"api_key,omitempty"`
+	TokenParam    string `json:"token_param,omitempty"`
+	TokenHeader   string `json:"token_header,omitempty"`
+	Token         string `json:"token,omitempty"`
+}
+
+const defAPIKeyHeader, defTokenParam = "DD-API-KEY", "token"
+
+var envRefRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${ENV_NAME} references in value with environment variables,
+// ${ENV_NAME:-default} falls back to default if unset, otherwise referencing an unset
+// variable is an error
+func expandEnv(value string) (string, error) {
+	var err error
+	value = envRefRegexp.ReplaceAllStringFunc(value, func(ref string) string {
+		sub := envRefRegexp.FindStringSubmatch(ref)
+		v, ok := os.LookupEnv(sub[1])
+		if !ok {
+			if sub[2] != "" {
+				return sub[3]
+			}
+			if err == nil {
+				err = fmt.Errorf("environment variable %s not set", sub[1])
+			}
+		}
+
+		return v
+	})
+
+	return value, err
+}
+
+// requestHeaders returns custom headers and authentication headers of task
+func (tkconf *TaskConfig) requestHeaders() (http.Header, error) {
+	header := make(http.Header)
+	for k, v := range tkconf.Headers {
+		v, err := expandEnv(v)
+		if err != nil {
+			return nil, err
+		}
+		header.Set(k, v)
+	}
+
+	auth := tkconf.Auth
+	if auth == nil {
+		return header, nil
+	}
+	if auth.BearerToken != "" {
+		token, err := expandEnv(auth.BearerToken)
+		if err != nil {
+			return nil, err
+		}
+		header.Set("Authorization", "Bearer "+token)
+	}
+	if auth.BasicUser != "" {
+		user, err := expandEnv(auth.BasicUser)
+		if err != nil {
+			return nil, err
+		}
+		pswd, err := expandEnv(auth.BasicPassword)
+		if err != nil {
+			return nil, err
+		}
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+pswd)))
+	}
+	if auth.APIKey != "" {
+		key, err := expandEnv(auth.APIKey)
+		if err != nil {
+			return nil, err
+		}
+		name := auth.APIKeyHeader
+		if name == "" {
+			name = defAPIKeyHeader
+		}
+		header.Set(name, key)
+	}
+	if auth.Token != "" && auth.TokenHeader != "" {
+		token, err := expandEnv(auth.Token)
+		if err != nil {
+			return nil, err
+		}
+		header.Set(auth.TokenHeader, token)
+	}
+
+	return header, nil
+}
+
+// withTokenParam appends the auth token as query parameter of collector endpoint unless
+// it is sent in header
+func (tkconf *TaskConfig) withTokenParam(endpoint string) (string, error) {
+	if tkconf.Auth == nil || tkconf.Auth.Token == "" || tkconf.Auth.TokenHeader != "" {
+		return endpoint, nil
+	}
+
+	token, err := expandEnv(tkconf.Auth.Token)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	name := tkconf.Auth.TokenParam
+	if name == "" {
+		name = defTokenParam
+	}
+	query := u.Query()
+	query.Set(name, token)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
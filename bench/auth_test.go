@@ -0,0 +1,62 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"testing"
+)
+
+func TestRequestHeaders(t *testing.T) {
+	t.Setenv("DKB_TEST_TOKEN", "secret")
+
+	tkconf := NewTaskConfig(
+		TracerWithCollector("http", "127.0.0.1", 9529, "/v0.4/traces"),
+		TracerWithHeaders(map[string]string{"X-Bench": "dkb"}, &AuthConfig{APIKey: "${DKB_TEST_TOKEN}", Token: "${DKB_TEST_TOKEN}"}),
+	)
+	header, err := tkconf.requestHeaders()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if header.Get("X-Bench") != "dkb" || header.Get(defAPIKeyHeader) != "secret" {
+		t.Fatalf("unexpected headers: %v", header)
+	}
+
+	endpoint, err := tkconf.collectorEndpoint()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if endpoint != "http://127.0.0.1:9529/v0.4/traces?token=secret" {
+		t.Fatalf("unexpected endpoint: %s", endpoint)
+	}
+
+	tkconf.Auth.TokenHeader = "X-Token"
+	if header, err = tkconf.requestHeaders(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if endpoint, err = tkconf.collectorEndpoint(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if header.Get("X-Token") != "secret" || endpoint != "http://127.0.0.1:9529/v0.4/traces" {
+		t.Fatalf("expect token in header only got header: %v endpoint: %s", header, endpoint)
+	}
+
+	tkconf.Auth = &AuthConfig{BearerToken: "${DKB_TEST_UNSET}"}
+	if _, err = tkconf.requestHeaders(); err == nil {
+		t.Fatal("expect error for unset environment variable")
+	}
+}
@@ -0,0 +1,735 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+	"github.com/CodapeWild/dktrace-data-benchmark/format"
+	"github.com/CodapeWild/dktrace-data-benchmark/route"
+)
+
+type TracerConfigOption func(tkconf *TaskConfig)
+
+func TracerWithName(name string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Name = name
+	}
+}
+
+func TracerWithTags(tags ...string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Tags = tags
+	}
+}
+
+func TracerWithTracer(tracer string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Tracer = tracer
+	}
+}
+
+func TracerWithVersion(version string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Version = version
+	}
+}
+
+func TracerWithRoute(path string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.RouteConfig = path
+	}
+}
+
+// TracerWithInlineRoute sets the route of task in place of route_config
+func TracerWithInlineRoute(r route.Route) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Route = r
+	}
+}
+
+func TracerWithAmplifier(threads, repeat int) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.SendThreads = threads
+		tkconf.SendTimesPerThread = repeat
+	}
+}
+
+func TracerWithCollector(proto string, ip string, port int, path string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.CollectorProto = proto
+		tkconf.CollectorIP = ip
+		tkconf.CollectorPort = port
+		tkconf.CollectorPath = path
+	}
+}
+
+// TracerWithCollectorSocket sends to collector listening on Unix socket, path is the HTTP path
+// of requests
+func TracerWithCollectorSocket(socket, path string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.CollectorProto = CollectorUnix
+		tkconf.CollectorSocket = socket
+		tkconf.CollectorIP = ""
+		tkconf.CollectorPort = 0
+		tkconf.CollectorPath = path
+	}
+}
+
+// TracerWithAgentSocket makes the tracer send to the capture agent over a Unix socket
+func TracerWithAgentSocket(on bool) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.AgentSocket = on
+	}
+}
+
+func TracerWithBatch(by string, size int) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.BatchBy = by
+		tkconf.BatchSize = size
+	}
+}
+
+func TracerWithCompression(encoding string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Compression = encoding
+	}
+}
+
+func TracerWithTLS(tlsConf *TLSConfig) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.TLS = tlsConf
+	}
+}
+
+// TLSConfig applies to collector with https protocol
+type TLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+func TracerWithConn(conn *ConnConfig) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Conn = conn
+	}
+}
+
+// ConnConfig tunes connections of every sender thread towards collector, see agent.ConnOptions
+type ConnConfig struct {
+	DisableTCPKeepAlive bool   `json:"disable_tcp_keep_alive,omitempty"`
+	MaxConns            int    `json:"max_conns,omitempty"`
+	HTTP2               string `json:"http2,omitempty"`
+	RequestTimeout      string `json:"request_timeout,omitempty"`
+	NewConnPerRequest   bool   `json:"new_conn_per_request,omitempty"`
+	UnixSocket          string `json:"unix_socket,omitempty"`
+}
+
+// options converts conn into connection options of amplifier towards collector of scheme
+func (conn *ConnConfig) options(scheme string) (*agent.ConnOptions, error) {
+	if conn == nil {
+		return nil, nil
+	}
+
+	opts := &agent.ConnOptions{
+		DisableTCPKeepAlive: conn.DisableTCPKeepAlive,
+		MaxConns:            conn.MaxConns,
+		HTTP2:               conn.HTTP2,
+		NewConnPerRequest:   conn.NewConnPerRequest,
+		UnixSocket:          conn.UnixSocket,
+	}
+	if conn.RequestTimeout != "" {
+		var err error
+		if opts.RequestTimeout, err = time.ParseDuration(conn.RequestTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, agent.CheckConn(opts, scheme)
+}
+
+func TracerWithRetry(retry *RetryConfig, queue *QueueConfig) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Retry = retry
+		tkconf.Queue = queue
+	}
+}
+
+// RetryConfig emulates tracers retrying payloads rejected by collector, see agent.RetryOptions
+type RetryConfig struct {
+	MaxAttempts int    `json:"max_attempts"`
+	Backoff     string `json:"backoff,omitempty"`
+	MaxBackoff  string `json:"max_backoff,omitempty"`
+	RetryOn     []int  `json:"retry_on,omitempty"`
+	RetryAfter  bool   `json:"retry_after,omitempty"`
+}
+
+// options converts retry into retry options of amplifier
+func (retry *RetryConfig) options() (*agent.RetryOptions, error) {
+	if retry == nil {
+		return nil, nil
+	}
+
+	opts := &agent.RetryOptions{MaxAttempts: retry.MaxAttempts, RetryOn: retry.RetryOn, RetryAfter: retry.RetryAfter}
+	var err error
+	if retry.Backoff != "" {
+		if opts.Backoff, err = time.ParseDuration(retry.Backoff); err != nil {
+			return nil, err
+		}
+	}
+	if retry.MaxBackoff != "" {
+		if opts.MaxBackoff, err = time.ParseDuration(retry.MaxBackoff); err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, agent.CheckRetry(opts)
+}
+
+// QueueConfig bounds payloads waiting to be sent by every thread, see agent.WithQueue
+type QueueConfig struct {
+	Size     int    `json:"size"`
+	Interval string `json:"interval"`
+}
+
+func TracerWithHeaders(headers map[string]string, auth *AuthConfig) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Headers = headers
+		tkconf.Auth = auth
+	}
+}
+
+func TracerWithArchive(path string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Tracer = Replay
+		tkconf.Archive = path
+	}
+}
+
+func TracerWithMonitor(mconf *MonitorConfig) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Monitor = mconf
+	}
+}
+
+func TracerWithCollectorMetrics(url string, names []string, accepted, dropped string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.CollectorMetricsURL = url
+		tkconf.CollectorMetrics = names
+		tkconf.CollectorAcceptedMetric = accepted
+		tkconf.CollectorDroppedMetric = dropped
+	}
+}
+
+func TracerWithParallelGroup(group string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.ParallelGroup = group
+	}
+}
+
+func TracerWithTimeout(timeout string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Timeout = timeout
+	}
+}
+
+func TracerWithWarmup(requests int, duration string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.WarmupRequests = requests
+		tkconf.WarmupDuration = duration
+	}
+}
+
+func TracerWithCaptureTimeout(timeout string, proceed bool) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.CaptureTimeout = timeout
+		tkconf.CaptureProceed = proceed
+	}
+}
+
+func TracerWithWorkers(workers []string, startDelay string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.Workers = workers
+		tkconf.WorkerStartDelay = startDelay
+	}
+}
+
+// TracerWithWorkerToken sets token sent to workers started with the same token, see WorkerWithToken.
+func TracerWithWorkerToken(token string) TracerConfigOption {
+	return func(tkconf *TaskConfig) {
+		tkconf.WorkerToken = token
+	}
+}
+
+type TaskConfig struct {
+	Name        string   `json:"name"`
+	Tags        []string `json:"tags,omitempty"`
+	Tracer      string   `json:"tracer"`
+	Version     string   `json:"version"`
+	RouteConfig string   `json:"route_config"`
+	// inline route used instead of route_config
+	Route              route.Route       `json:"route,omitempty"`
+	SendThreads        int               `json:"send_threads"`
+	SendTimesPerThread int               `json:"send_times_per_thread"`
+	CollectorProto     string            `json:"collector_proto"`
+	CollectorIP        string            `json:"collector_ip"`
+	CollectorPort      int               `json:"collector_port"`
+	CollectorPath      string            `json:"collector_path"`
+	CollectorSocket    string            `json:"collector_socket,omitempty"`
+	AgentSocket        bool              `json:"agent_socket,omitempty"`
+	BatchBy            string            `json:"batch_by,omitempty"`
+	BatchSize          int               `json:"batch_size,omitempty"`
+	Compression        string            `json:"compression,omitempty"`
+	TLS                *TLSConfig        `json:"tls,omitempty"`
+	Conn               *ConnConfig       `json:"conn,omitempty"`
+	Retry              *RetryConfig      `json:"retry,omitempty"`
+	Queue              *QueueConfig      `json:"queue,omitempty"`
+	Headers            map[string]string `json:"headers,omitempty"`
+	Auth               *AuthConfig       `json:"auth,omitempty"`
+	Archive            string            `json:"archive,omitempty"`
+	Monitor            *MonitorConfig    `json:"monitor,omitempty"`
+	Timeout            string            `json:"timeout,omitempty"`
+	// requests sent by every thread before measured ones, excluded from stats
+	WarmupRequests int    `json:"warmup_requests,omitempty"`
+	WarmupDuration string `json:"warmup_duration,omitempty"`
+	// wait for spans expected by route, then proceed with spans captured or fail
+	CaptureTimeout string `json:"capture_timeout,omitempty"`
+	CaptureProceed bool   `json:"capture_proceed,omitempty"`
+	// collector self-metrics scraped before and after task
+	CollectorMetricsURL      string   `json:"collector_metrics_url,omitempty"`
+	CollectorMetrics         []string `json:"collector_metrics,omitempty"`
+	CollectorMetricsInterval string   `json:"collector_metrics_interval,omitempty"`
+	CollectorAcceptedMetric  string   `json:"collector_accepted_metric,omitempty"`
+	CollectorDroppedMetric   string   `json:"collector_dropped_metric,omitempty"`
+	// worker addresses sharing threads of task, see distributeTask
+	Workers          []string `json:"workers,omitempty"`
+	WorkerStartDelay string   `json:"worker_start_delay,omitempty"`
+	// bearer token required by workers, ${ENV} references are expanded when distributed
+	WorkerToken string `json:"worker_token,omitempty"`
+	// tasks of the same group run together when parallel is enabled, see PlanTasks
+	ParallelGroup string `json:"parallel_group,omitempty"`
+}
+
+// loadRoute returns the inline route of task, or loads it from route_config
+func (tkconf *TaskConfig) loadRoute() (route.Route, error) {
+	if len(tkconf.Route) != 0 {
+		return tkconf.Route, nil
+	}
+
+	return route.NewRouteFromFile(tkconf.RouteConfig)
+}
+
+func (tkconf *TaskConfig) With(opts ...TracerConfigOption) *TaskConfig {
+	for _, opt := range opts {
+		opt(tkconf)
+	}
+
+	return tkconf
+}
+
+func (tkconf *TaskConfig) Print() {
+	log.Println("------")
+	log.Printf("Name: %s", tkconf.Name)
+	if len(tkconf.Tags) != 0 {
+		log.Printf("Tags: %s", strings.Join(tkconf.Tags, ", "))
+	}
+	log.Printf("Tracer: %s", tkconf.Tracer)
+	log.Printf("Version: %s", tkconf.Version)
+	if tkconf.Tracer == Replay {
+		log.Printf("Archive: %s", tkconf.Archive)
+	} else if len(tkconf.Route) != 0 {
+		log.Printf("Route: inline, %d hops", len(tkconf.Route))
+	} else {
+		log.Printf("Route: %s", tkconf.RouteConfig)
+	}
+	log.Printf("Threads: %d Repeated: %d", tkconf.SendThreads, tkconf.SendTimesPerThread)
+	if tkconf.CollectorProto == CollectorUnix {
+		log.Printf("Collector: <unix://%s> path: %s", tkconf.CollectorSocket, tkconf.CollectorPath)
+	} else {
+		log.Printf("Collector: <%s://%s:%d%s>", tkconf.CollectorProto, tkconf.CollectorIP, tkconf.CollectorPort, tkconf.CollectorPath)
+	}
+	if tkconf.AgentSocket {
+		log.Println("Agent: unix socket")
+	}
+	if tkconf.BatchBy != "" {
+		log.Printf("Batch: %d %s", tkconf.BatchSize, tkconf.BatchBy)
+	}
+	if tkconf.Compression != "" {
+		log.Printf("Compression: %s", tkconf.Compression)
+	}
+	if tkconf.TLS != nil {
+		log.Printf("TLS: CA: %s Cert: %s Key: %s ServerName: %s InsecureSkipVerify: %v", tkconf.TLS.CAFile, tkconf.TLS.CertFile, tkconf.TLS.KeyFile, tkconf.TLS.ServerName, tkconf.TLS.InsecureSkipVerify)
+	}
+	if conn := tkconf.Conn; conn != nil {
+		log.Printf("Conn: disable TCP keep-alive: %v max: %d http2: %s request timeout: %s new per request: %v unix socket: %s", conn.DisableTCPKeepAlive, conn.MaxConns, conn.HTTP2, conn.RequestTimeout, conn.NewConnPerRequest, conn.UnixSocket)
+	}
+	if retry := tkconf.Retry; retry != nil {
+		log.Printf("Retry: attempts: %d backoff: %s max: %s on: %v Retry-After: %v", retry.MaxAttempts, retry.Backoff, retry.MaxBackoff, retry.RetryOn, retry.RetryAfter)
+	}
+	if queue := tkconf.Queue; queue != nil {
+		log.Printf("Queue: size: %d interval: %s", queue.Size, queue.Interval)
+	}
+	for k, v := range tkconf.Headers {
+		log.Printf("Header: %s: %s", k, v)
+	}
+	if tkconf.Auth != nil {
+		// print references only, secrets are expected to be taken from environment
+		log.Printf("Auth: Bearer: %v Basic: %v APIKey: %v Token: %v", tkconf.Auth.BearerToken != "", tkconf.Auth.BasicUser != "", tkconf.Auth.APIKey != "", tkconf.Auth.Token != "")
+	}
+	if tkconf.Monitor != nil {
+		log.Printf("Monitor: pid: %d process: %s interval: %s", tkconf.Monitor.PID, tkconf.Monitor.Process, tkconf.Monitor.Interval)
+	}
+	if tkconf.CollectorMetricsURL != "" {
+		log.Printf("Collector metrics: %s %v accepted: %s dropped: %s", tkconf.CollectorMetricsURL, tkconf.CollectorMetrics, tkconf.CollectorAcceptedMetric, tkconf.CollectorDroppedMetric)
+	}
+	if tkconf.Timeout != "" {
+		log.Printf("Timeout: %s", tkconf.Timeout)
+	}
+	if tkconf.WarmupRequests != 0 || tkconf.WarmupDuration != "" {
+		log.Printf("Warm-up: requests: %d duration: %s", tkconf.WarmupRequests, tkconf.WarmupDuration)
+	}
+	if tkconf.CaptureTimeout != "" {
+		log.Printf("Capture timeout: %s proceed: %v", tkconf.CaptureTimeout, tkconf.CaptureProceed)
+	}
+	if len(tkconf.Workers) != 0 {
+		log.Printf("Workers: %v start delay: %s", tkconf.Workers, tkconf.WorkerStartDelay)
+	}
+}
+
+// collectorEndpoint assembles the URL replayed requests are sent to
+func (tkconf *TaskConfig) collectorEndpoint() (string, error) {
+	proto := tkconf.CollectorProto
+	switch proto {
+	case "":
+		proto = "http"
+	case "http", "https":
+	case CollectorUnix:
+		if tkconf.CollectorSocket == "" {
+			return "", fmt.Errorf("collector_socket required by collector protocol: %s", proto)
+		}
+
+		// the host is never dialed, see amplifierOptions
+		return tkconf.withTokenParam("http://unix" + tkconf.CollectorPath)
+	default:
+		return "", fmt.Errorf("unsupported collector protocol: %s", proto)
+	}
+
+	return tkconf.withTokenParam(fmt.Sprintf("%s://%s:%d%s", proto, tkconf.CollectorIP, tkconf.CollectorPort, tkconf.CollectorPath))
+}
+
+// amplifierOptions converts task configuration into options of traces amplifier
+func (tkconf *TaskConfig) amplifierOptions() ([]agent.AmplifierOption, error) {
+	if err := agent.CheckBatch(tkconf.BatchBy, tkconf.BatchSize); err != nil {
+		return nil, err
+	}
+	if err := agent.CheckCompression(tkconf.Compression); err != nil {
+		return nil, err
+	}
+
+	header, err := tkconf.requestHeaders()
+	if err != nil {
+		return nil, err
+	}
+	captureTimeout := defCaptureTimeout
+	if tkconf.CaptureTimeout != "" {
+		if captureTimeout, err = time.ParseDuration(tkconf.CaptureTimeout); err != nil {
+			return nil, err
+		}
+	}
+	var warmup time.Duration
+	if tkconf.WarmupDuration != "" {
+		if warmup, err = time.ParseDuration(tkconf.WarmupDuration); err != nil {
+			return nil, err
+		}
+	}
+	scheme := tkconf.CollectorProto
+	if scheme == "" || scheme == CollectorUnix {
+		scheme = "http"
+	}
+	conn, err := tkconf.Conn.options(scheme)
+	if err != nil {
+		return nil, err
+	}
+	if tkconf.CollectorProto == CollectorUnix {
+		if conn == nil {
+			conn = &agent.ConnOptions{}
+		}
+		conn.UnixSocket = tkconf.CollectorSocket
+	}
+	retry, err := tkconf.Retry.options()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []agent.AmplifierOption{
+		agent.WithBatch(tkconf.BatchBy, tkconf.BatchSize),
+		agent.WithCompression(tkconf.Compression),
+		agent.WithHeaders(header),
+		agent.WithCaptureTimeout(captureTimeout, tkconf.CaptureProceed),
+		agent.WithWarmup(tkconf.WarmupRequests, warmup),
+		agent.WithConn(conn),
+		agent.WithRetry(retry),
+	}
+	if tkconf.Queue != nil {
+		var interval time.Duration
+		if tkconf.Queue.Interval != "" {
+			if interval, err = time.ParseDuration(tkconf.Queue.Interval); err != nil {
+				return nil, err
+			}
+		}
+		if err = agent.CheckQueue(tkconf.Queue.Size, interval); err != nil {
+			return nil, err
+		}
+		opts = append(opts, agent.WithQueue(tkconf.Queue.Size, interval))
+	}
+	if tkconf.CollectorProto == "https" {
+		var tlsConf = &TLSConfig{}
+		if tkconf.TLS != nil {
+			tlsConf = tkconf.TLS
+		}
+		clientTLS, err := agent.NewClientTLSConfig(tlsConf.CAFile, tlsConf.CertFile, tlsConf.KeyFile, tlsConf.ServerName, tlsConf.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, agent.WithTLS(clientTLS))
+	}
+
+	return opts, nil
+}
+
+func NewTaskConfig(opts ...TracerConfigOption) *TaskConfig {
+	tkconf := &TaskConfig{}
+	for _, opt := range opts {
+		opt(tkconf)
+	}
+
+	return tkconf
+}
+
+type BenchConfigOption func(bconf *BenchConfig)
+
+func BenchWithLog(enable bool) BenchConfigOption {
+	return func(bconf *BenchConfig) {
+		bconf.DisableLog = enable
+	}
+}
+
+func BenchWithTasks(tasks ...*TaskConfig) BenchConfigOption {
+	return func(bconf *BenchConfig) {
+		for _, new := range tasks {
+			found := false
+			for _, origin := range bconf.Tasks {
+				if origin.Name == new.Name {
+					origin = new
+					found = true
+					break
+				}
+			}
+			if !found {
+				bconf.Tasks = append(bconf.Tasks, new)
+			}
+		}
+	}
+}
+
+func BenchWithOutput(path string) BenchConfigOption {
+	return func(bconf *BenchConfig) {
+		bconf.Output = path
+	}
+}
+
+func BenchWithTimeout(timeout string) BenchConfigOption {
+	return func(bconf *BenchConfig) {
+		bconf.Timeout = timeout
+	}
+}
+
+func BenchWithParallel(parallel bool, cpuBudget int) BenchConfigOption {
+	return func(bconf *BenchConfig) {
+		bconf.Parallel = parallel
+		bconf.CPUBudget = cpuBudget
+	}
+}
+
+func BenchWithCooldown(cooldown string) BenchConfigOption {
+	return func(bconf *BenchConfig) {
+		bconf.Cooldown = cooldown
+	}
+}
+
+func BenchWithMetrics(address string) BenchConfigOption {
+	return func(bconf *BenchConfig) {
+		bconf.MetricsAddress = address
+	}
+}
+
+type BenchConfig struct {
+	DisableLog     bool          `json:"disable_log"`
+	Output         string        `json:"output,omitempty"`
+	MetricsAddress string        `json:"metrics_address,omitempty"`
+	Timeout        string        `json:"timeout,omitempty"`
+	Tasks          []*TaskConfig `json:"tasks"`
+	// pause between tasks run one after another, so that the collector settles down
+	Cooldown string `json:"cooldown,omitempty"`
+	// run tasks of parallel groups together within cores of cpu_budget
+	Parallel  bool `json:"parallel,omitempty"`
+	CPUBudget int  `json:"cpu_budget,omitempty"`
+	// tasks grouped into suites and generated by matrices, see suite.go
+	Suites   []*SuiteConfig  `json:"suites,omitempty"`
+	Matrices []*MatrixConfig `json:"matrices,omitempty"`
+	// loaded with includes or environment variables interpolated, see LoadBenchConfigFile
+	resolved bool
+	// tasks merged from environment to the tasks of file they replaced, see MergeEnvTasks
+	envTasks map[*TaskConfig]*TaskConfig
+}
+
+func (bconf *BenchConfig) With(opts ...BenchConfigOption) *BenchConfig {
+	for _, opt := range opts {
+		opt(bconf)
+	}
+
+	return bconf
+}
+
+func (bconf *BenchConfig) Print() {
+	log.Println("trace benchmark config:")
+	log.Println("### ### ###")
+	if bconf.DisableLog {
+		log.Println("log: disabled")
+	} else {
+		log.Println("log: enabled")
+	}
+	if bconf.Output != "" {
+		log.Printf("output: %s", bconf.Output)
+	}
+	if bconf.MetricsAddress != "" {
+		log.Printf("metrics: %s", bconf.MetricsAddress)
+	}
+	if bconf.Timeout != "" {
+		log.Printf("timeout: %s", bconf.Timeout)
+	}
+	if bconf.Cooldown != "" {
+		log.Printf("cooldown: %s", bconf.Cooldown)
+	}
+	for _, tkconf := range bconf.Tasks {
+		tkconf.Print()
+	}
+}
+
+func NewBenchmarkConfig(opts ...BenchConfigOption) *BenchConfig {
+	dconfig := &BenchConfig{}
+	for _, opt := range opts {
+		opt(dconfig)
+	}
+
+	return dconfig
+}
+
+// CollectorUnix is the collector protocol of HTTP over Unix socket at collector_socket
+const CollectorUnix = "unix"
+
+// tracers registered by this package
+const (
+	DDTrace string = "ddtrace"
+	Jaeger  string = "jaeger"
+	// replay archived payloads instead of running a tracer
+	Replay string = "replay"
+)
+
+// defCaptureTimeout applies to tasks without capture_timeout, so that a tracer dropping
+// spans never hangs a task
+var defCaptureTimeout = time.Minute
+
+func MergeTasks(dst *[]*TaskConfig, src []*TaskConfig) {
+	for _, s := range src {
+		found := false
+		for i, d := range *dst {
+			if d.Name == s.Name {
+				found = true
+				(*dst)[i] = s
+				break
+			}
+		}
+		if !found {
+			*dst = append(*dst, s)
+		}
+	}
+}
+
+// MergeEnvTasks merges tasks from environment like MergeTasks, they are run but kept out of
+// dumped configuration which keeps the tasks of file they replaced instead
+func (bconf *BenchConfig) MergeEnvTasks(tasks []*TaskConfig) {
+	if bconf.envTasks == nil {
+		bconf.envTasks = make(map[*TaskConfig]*TaskConfig)
+	}
+	for _, task := range tasks {
+		var replaced *TaskConfig
+		for _, t := range bconf.Tasks {
+			if t.Name == task.Name {
+				replaced = t
+				break
+			}
+		}
+		if orig, ok := bconf.envTasks[replaced]; ok {
+			delete(bconf.envTasks, replaced)
+			replaced = orig
+		}
+		bconf.envTasks[task] = replaced
+	}
+	MergeTasks(&bconf.Tasks, tasks)
+}
+
+// fileTasks returns tasks to persist, tasks from environment are swapped back for the tasks
+// of file they replaced, tasks changed since merged are persisted as they are new objects
+func (bconf *BenchConfig) fileTasks() []*TaskConfig {
+	tasks := make([]*TaskConfig, 0, len(bconf.Tasks))
+	for _, task := range bconf.Tasks {
+		replaced, ok := bconf.envTasks[task]
+		if !ok {
+			tasks = append(tasks, task)
+		} else if replaced != nil {
+			tasks = append(tasks, replaced)
+		}
+	}
+
+	return tasks
+}
+
+// DumpBenchConfigFile writes configuration in format by extension of path, configuration
+// resolved from includes or environment can't be written back without losing them, tasks
+// merged from environment are left out.
+func DumpBenchConfigFile(path string, benchConf *BenchConfig) error {
+	if benchConf.resolved {
+		return fmt.Errorf("configuration resolved from includes or environment variables is read only, edit its files instead")
+	}
+	dump := *benchConf
+	dump.Tasks = benchConf.fileTasks()
+	bts, err := format.Marshal(path, &dump)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, bts, 0644)
+}
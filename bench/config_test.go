@@ -0,0 +1,50 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeEnvTasks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	bconf := &BenchConfig{Tasks: []*TaskConfig{{Name: "dd", SendThreads: 5}}}
+
+	// tasks from environment are run but not written back
+	bconf.MergeEnvTasks([]*TaskConfig{{Name: "dd", SendThreads: 9}, {Name: "env"}})
+	if len(bconf.Tasks) != 2 || bconf.Tasks[0].SendThreads != 9 {
+		t.Fatalf("environment tasks not merged: %v", bconf.Tasks)
+	}
+	if err := DumpBenchConfigFile(path, bconf); err != nil {
+		t.Fatal(err.Error())
+	}
+	saved, err := LoadBenchConfigFile(path)
+	if err != nil || len(saved.Tasks) != 1 || saved.Tasks[0].SendThreads != 5 {
+		t.Fatalf("environment tasks written back: %v", err)
+	}
+
+	// a task from environment changed since merged is a new object and written back
+	bconf.Tasks[0] = &TaskConfig{Name: "dd", SendThreads: 7}
+	if err = DumpBenchConfigFile(path, bconf); err != nil {
+		t.Fatal(err.Error())
+	}
+	if saved, err = LoadBenchConfigFile(path); err != nil || len(saved.Tasks) != 1 || saved.Tasks[0].SendThreads != 7 {
+		t.Fatalf("changed task not written back: %v", err)
+	}
+}
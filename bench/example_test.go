@@ -0,0 +1,83 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+	"github.com/CodapeWild/dktrace-data-benchmark/bench"
+	"github.com/CodapeWild/dktrace-data-benchmark/route"
+	"github.com/CodapeWild/dktrace-data-benchmark/tracer"
+)
+
+func ExampleRunner_Run() {
+	task := bench.NewTaskConfig(
+		bench.TracerWithName("dd-v0.4"),
+		bench.TracerWithTracer(bench.DDTrace),
+		bench.TracerWithRoute("./routes/user-login.json"),
+		bench.TracerWithAmplifier(3, 10),
+		bench.TracerWithCollector("http", "127.0.0.1", 9529, "/v0.4/traces"),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	res, err := bench.NewRunner().Run(ctx, task)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	log.Printf("p99: %s errors: %d", res.Sent.Latency.Quantile(0.99), res.Sent.Errors)
+}
+
+func ExampleTracerWithInlineRoute() {
+	// user-agent calls auth-server which reads redis in its own service
+	r := route.Route{
+		{ID: 1, Name: "user-agent", Calls: []*route.Call{{ID: 2, Outgoing: true}}},
+		{ID: 2, Name: "auth-server", Action: "/auth", Calls: []*route.Call{{ID: 3}}},
+		{ID: 3, Name: "redis", Action: "get user-login-status", Status: "ok"},
+	}
+	task := bench.NewTaskConfig(
+		bench.TracerWithName("jg-inline"),
+		bench.TracerWithTracer(bench.Jaeger),
+		bench.TracerWithInlineRoute(r),
+		bench.TracerWithAmplifier(3, 10),
+		bench.TracerWithCollector("http", "127.0.0.1", 9529, "/apis/traces"),
+	)
+	if err := bench.Validate(task); err != nil {
+		log.Fatalln(err.Error())
+	}
+	fmt.Println(r.CreateTree(&tracer.JgTracerWrapper{}).Count())
+	// Output: 3
+}
+
+func ExampleRegisterProtocol() {
+	// amplify payloads of a tracer living in another package
+	err := bench.RegisterProtocol(&bench.Protocol{
+		Name:           "my-tracer",
+		NewTracer:      func() tracer.Tracer { return &tracer.DDTracerWrapper{} },
+		StartAgent:     agent.StartDDAgent,
+		Versions:       []string{"v1"},
+		CollectorPaths: []string{"/v0.4/traces"},
+	})
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/format"
+)
+
+// keys of configuration trees not interpolated at loading, they are expanded when
+// requests are built so that secrets stay out of configurations and results
+var lazyEnvKeys = map[string]bool{"auth": true, "headers": true, "worker_token": true}
+
+// LoadBenchConfigFile loads configuration in JSON, YAML or TOML by extension of path.
+// Files listed by include, relative to the including file, are loaded first and the
+// including file is merged onto them: objects are merged by key, lists of objects with
+// name are merged by name and other values are replaced. ${ENV_NAME} and
+// ${ENV_NAME:-default} references in string values are then interpolated.
+func LoadBenchConfigFile(path string) (*BenchConfig, error) {
+	tree, included, err := loadConfigTree(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	interpolated, err := interpolateTree(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	var benchConf BenchConfig
+	if err = format.FromTree(tree, &benchConf); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if err = benchConf.checkNames(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	benchConf.resolved = included || interpolated
+
+	return &benchConf, nil
+}
+
+// loadConfigTree loads configuration at path merged onto its includes, stack holds files
+// being loaded to detect include cycles
+func loadConfigTree(path string, stack []string) (map[string]interface{}, bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, p := range stack {
+		if p == abs {
+			return nil, false, fmt.Errorf("include cycle: %s", path)
+		}
+	}
+	stack = append(stack, abs)
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	tree, err := format.Decode(path, bts)
+	if err != nil {
+		return nil, false, err
+	}
+	conf, ok := tree.(map[string]interface{})
+	if !ok {
+		return nil, false, fmt.Errorf("%s: configuration must be an object", path)
+	}
+
+	includes, err := includePaths(conf["include"])
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	delete(conf, "include")
+	if len(includes) == 0 {
+		return conf, false, nil
+	}
+
+	base := make(map[string]interface{})
+	for _, inc := range includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(path), inc)
+		}
+		sub, _, err := loadConfigTree(inc, stack)
+		if err != nil {
+			return nil, false, err
+		}
+		base = mergeTree(base, sub).(map[string]interface{})
+	}
+
+	return mergeTree(base, conf).(map[string]interface{}), true, nil
+}
+
+func includePaths(v interface{}) ([]string, error) {
+	switch inc := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{inc}, nil
+	case []interface{}:
+		var paths []string
+		for _, p := range inc {
+			s, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("include must list file paths")
+			}
+			paths = append(paths, s)
+		}
+
+		return paths, nil
+	default:
+		return nil, fmt.Errorf("include must be a file path or a list of them")
+	}
+}
+
+// mergeTree merges src onto dst and returns the result, dst may be modified
+func mergeTree(dst, src interface{}) interface{} {
+	switch s := src.(type) {
+	case map[string]interface{}:
+		d, ok := dst.(map[string]interface{})
+		if !ok {
+			return src
+		}
+		for k, v := range s {
+			d[k] = mergeTree(d[k], v)
+		}
+
+		return d
+	case []interface{}:
+		d, ok := dst.([]interface{})
+		if !ok || !namedList(d) || !namedList(s) {
+			return src
+		}
+		for _, v := range s {
+			name := v.(map[string]interface{})["name"]
+			found := false
+			for i, dv := range d {
+				if dv.(map[string]interface{})["name"] == name {
+					d[i] = mergeTree(dv, v)
+					found = true
+					break
+				}
+			}
+			if !found {
+				d = append(d, v)
+			}
+		}
+
+		return d
+	default:
+		return src
+	}
+}
+
+// namedList reports whether list holds objects with string name only
+func namedList(list []interface{}) bool {
+	for _, v := range list {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok = m["name"].(string); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// interpolateTree expands environment variables in string values of tree in place, it
+// reports whether any value changed
+func interpolateTree(tree interface{}) (bool, error) {
+	var changed bool
+	switch t := tree.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			if lazyEnvKeys[k] {
+				continue
+			}
+			if s, ok := v.(string); ok {
+				exp, err := expandEnv(s)
+				if err != nil {
+					return false, fmt.Errorf("%s: %s", k, err.Error())
+				}
+				changed = changed || exp != s
+				t[k] = exp
+			} else {
+				c, err := interpolateTree(v)
+				if err != nil {
+					return false, fmt.Errorf("%s: %s", k, err.Error())
+				}
+				changed = changed || c
+			}
+		}
+	case []interface{}:
+		for i, v := range t {
+			if s, ok := v.(string); ok {
+				exp, err := expandEnv(s)
+				if err != nil {
+					return false, err
+				}
+				changed = changed || exp != s
+				t[i] = exp
+			} else {
+				c, err := interpolateTree(v)
+				if err != nil {
+					return false, err
+				}
+				changed = changed || c
+			}
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,207 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBenchConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"suite.yaml": `
+timeout: 10m
+tasks:
+  - name: dd
+    tracer: ddtrace
+    route_config: ./routes/user-login.json
+    send_threads: 3
+    send_times_per_thread: 10
+    collector_ip: 127.0.0.1
+    collector_port: 9529
+    auth:
+      bearer_token: ${DKB_TEST_TOKEN}
+  - name: jg
+    tracer: jaeger
+    send_threads: 2
+    route:
+      - id: 1
+        name: user-agent
+        calls: [{id: 2, outgoing: true}]
+      - id: 2
+        name: ${DKB_TEST_SERVICE:-auth-server}
+`,
+		"staging.toml": `
+include = ["suite.yaml"]
+output = "./results-staging.json"
+
+[[tasks]]
+name = "dd"
+collector_ip = "${DKB_TEST_COLLECTOR}"
+collector_port = "${DKB_TEST_PORT}"
+
+[[tasks]]
+name = "extra"
+tracer = "replay"
+archive = "./archives/dd.jsonl"
+`,
+		"cycle-a.json": `{"include": "cycle-b.json"}`,
+		"cycle-b.json": `{"include": ["cycle-a.json"]}`,
+		"plain.yaml":   "tasks:\n  - name: plain\n    tracer: ddtrace\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	t.Setenv("DKB_TEST_COLLECTOR", "10.0.0.2")
+	t.Setenv("DKB_TEST_PORT", "19529")
+
+	bconf, err := LoadBenchConfigFile(filepath.Join(dir, "staging.toml"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if bconf.Timeout != "10m" || bconf.Output != "./results-staging.json" || len(bconf.Tasks) != 3 {
+		t.Fatalf("unexpected configuration: %+v", bconf)
+	}
+	dd, jg, extra := bconf.Tasks[0], bconf.Tasks[1], bconf.Tasks[2]
+	if dd.CollectorIP != "10.0.0.2" || dd.CollectorPort != 19529 || dd.SendThreads != 3 || dd.RouteConfig != "./routes/user-login.json" {
+		t.Fatalf("overlay not merged into task: %+v", dd)
+	}
+	if dd.Auth == nil || dd.Auth.BearerToken != "${DKB_TEST_TOKEN}" {
+		t.Fatalf("auth interpolated at loading: %+v", dd.Auth)
+	}
+	if len(jg.Route) != 2 || jg.Route[1].Name != "auth-server" {
+		t.Fatalf("unexpected inline route: %+v", jg.Route)
+	}
+	if extra.Name != "extra" || extra.Tracer != Replay {
+		t.Fatalf("unexpected task: %+v", extra)
+	}
+	if err = DumpBenchConfigFile(filepath.Join(dir, "out.json"), bconf); err == nil {
+		t.Fatal("expect resolved configuration read only")
+	}
+
+	if _, err = LoadBenchConfigFile(filepath.Join(dir, "cycle-a.json")); err == nil {
+		t.Fatal("expect include cycle error")
+	}
+
+	t.Setenv("DKB_TEST_PORT", "port")
+	if _, err = LoadBenchConfigFile(filepath.Join(dir, "staging.toml")); err == nil {
+		t.Fatal("expect error of invalid port")
+	}
+
+	// configuration without includes and references is written back in its format
+	path := filepath.Join(dir, "plain.yaml")
+	if bconf, err = LoadBenchConfigFile(path); err != nil {
+		t.Fatal(err.Error())
+	}
+	bconf.Tasks[0].SendThreads = 5
+	if err = DumpBenchConfigFile(path, bconf); err != nil {
+		t.Fatal(err.Error())
+	}
+	if bconf, err = LoadBenchConfigFile(path); err != nil || bconf.Tasks[0].SendThreads != 5 {
+		t.Fatalf("configuration not written back: %v", err)
+	}
+}
+
+func TestLoadBenchConfigFileResolve(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"self.yaml":        "include: self.yaml\n",
+		"cycle-a.yaml":     "include: sub/cycle-b.toml\n",
+		"sub/cycle-b.toml": `include = ["cycle-c.json"]`,
+		"sub/cycle-c.json": `{"include": "../cycle-a.yaml"}`,
+		"missing.json":     `{"include": "nowhere.json"}`,
+		"shared.json":      `{"timeout": "1m"}`,
+		"diamond.yaml":     "include: [shared.json, shared.json]\n",
+		"env.yaml": `
+timeout: ${DKB_TEST_TIMEOUT:-5m}
+output: ${DKB_TEST_OUTPUT:-}
+tasks:
+  - name: env
+    tracer: ddtrace
+    send_threads: ${DKB_TEST_THREADS}
+    capture_proceed: ${DKB_TEST_PROCEED:-true}
+    headers:
+      X-Token: ${DKB_TEST_UNSET_HEADER}
+    auth:
+      bearer_token: ${DKB_TEST_UNSET_TOKEN}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	for name, ok := range map[string]bool{"self.yaml": false, "cycle-a.yaml": false, "missing.json": false, "diamond.yaml": true} {
+		bconf, err := LoadBenchConfigFile(filepath.Join(dir, name))
+		if (err == nil) != ok {
+			t.Fatalf("%s: expect ok %v got error %v", name, ok, err)
+		}
+		if ok && bconf.Timeout != "1m" {
+			t.Fatalf("%s: unexpected configuration: %+v", name, bconf)
+		}
+	}
+
+	// variable without default is required, lazy keys are left for requests
+	path := filepath.Join(dir, "env.yaml")
+	if _, err := LoadBenchConfigFile(path); err == nil {
+		t.Fatal("expect error of variable not set")
+	}
+	t.Setenv("DKB_TEST_THREADS", "4")
+	bconf, err := LoadBenchConfigFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	task := bconf.Tasks[0]
+	if bconf.Timeout != "5m" || bconf.Output != "" || task.SendThreads != 4 || !task.CaptureProceed {
+		t.Fatalf("defaults not interpolated: %+v %+v", bconf, task)
+	}
+	if task.Headers["X-Token"] != "${DKB_TEST_UNSET_HEADER}" || task.Auth.BearerToken != "${DKB_TEST_UNSET_TOKEN}" {
+		t.Fatalf("lazy keys interpolated at loading: %v %+v", task.Headers, task.Auth)
+	}
+	if _, err = task.requestHeaders(); err == nil {
+		t.Fatal("expect error of header variable not set")
+	}
+
+	t.Setenv("DKB_TEST_TIMEOUT", "30s")
+	t.Setenv("DKB_TEST_PROCEED", "false")
+	t.Setenv("DKB_TEST_UNSET_HEADER", "secret")
+	if bconf, err = LoadBenchConfigFile(path); err != nil {
+		t.Fatal(err.Error())
+	}
+	if task = bconf.Tasks[0]; bconf.Timeout != "30s" || task.CaptureProceed {
+		t.Fatalf("variables not overriding defaults: %+v %+v", bconf, task)
+	}
+	t.Setenv("DKB_TEST_UNSET_TOKEN", "token")
+	if header, err := task.requestHeaders(); err != nil || header.Get("X-Token") != "secret" {
+		t.Fatalf("unexpected request headers: %v %v", header, err)
+	}
+
+	t.Setenv("DKB_TEST_PROCEED", "maybe")
+	if _, err = LoadBenchConfigFile(path); err == nil {
+		t.Fatal("expect error of invalid boolean")
+	}
+}
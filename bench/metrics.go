@@ -0,0 +1,131 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+)
+
+type TaskMetrics struct {
+	Task, Tracer string
+	Stats        *agent.Stats
+}
+
+// MetricsRegistry exposes stats of every task run in Prometheus text format, stats of
+// a task rerun replace the previous ones
+type MetricsRegistry struct {
+	sync.Mutex
+	tasks map[string]*TaskMetrics
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{tasks: make(map[string]*TaskMetrics)}
+}
+
+func (reg *MetricsRegistry) Register(task, tracer string, stats *agent.Stats) {
+	reg.Lock()
+	defer reg.Unlock()
+
+	reg.tasks[task] = &TaskMetrics{Task: task, Tracer: tracer, Stats: stats}
+}
+
+// Snapshot returns stats of tasks ordered by name
+func (reg *MetricsRegistry) Snapshot() []*TaskMetrics {
+	reg.Lock()
+	defer reg.Unlock()
+
+	var tms []*TaskMetrics
+	for _, tm := range reg.tasks {
+		tms = append(tms, &TaskMetrics{Task: tm.Task, Tracer: tm.Tracer, Stats: tm.Stats.Snapshot()})
+	}
+	sort.Slice(tms, func(i, j int) bool { return tms[i].Task < tms[j].Task })
+
+	return tms
+}
+
+func (reg *MetricsRegistry) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(resp, reg.Snapshot())
+}
+
+func writeMetrics(w io.Writer, tms []*TaskMetrics) {
+	type counter struct {
+		name, help, typ string
+		value           func(st *agent.Stats) int64
+	}
+	for _, c := range []counter{
+		{"dkb_requests_total", "Requests sent by amplifier.", "counter", func(st *agent.Stats) int64 { return st.Requests }},
+		{"dkb_spans_total", "Spans carried by requests sent.", "counter", func(st *agent.Stats) int64 { return st.Spans }},
+		{"dkb_bytes_total", "Request body bytes sent.", "counter", func(st *agent.Stats) int64 { return st.Bytes }},
+		{"dkb_in_flight_requests", "Requests waiting for response.", "gauge", func(st *agent.Stats) int64 { return st.InFlight }},
+		{"dkb_planned_requests", "Requests planned for all threads.", "gauge", func(st *agent.Stats) int64 { return st.Planned }},
+		{"dkb_retries_total", "Payloads sent again after failure.", "counter", func(st *agent.Stats) int64 { return st.Retries }},
+		{"dkb_dropped_total", "Payloads dropped after the last attempt.", "counter", func(st *agent.Stats) int64 { return st.Dropped }},
+		{"dkb_queue_dropped_total", "Payloads dropped while the send queue is full.", "counter", func(st *agent.Stats) int64 { return st.QueueDropped }},
+		{"dkb_dials_total", "Connections dialed by requests sent.", "counter", func(st *agent.Stats) int64 { return st.Dials }},
+		{"dkb_reuses_total", "Connections reused by requests sent.", "counter", func(st *agent.Stats) int64 { return st.Reuses }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", c.name, c.help, c.name, c.typ)
+		for _, tm := range tms {
+			fmt.Fprintf(w, "%s{%s} %d\n", c.name, tm.labels(), c.value(tm.Stats))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP dkb_errors_total Requests failed by class.\n# TYPE dkb_errors_total counter\n")
+	for _, tm := range tms {
+		for _, class := range []string{agent.ErrClassTransport, agent.ErrClass4xx, agent.ErrClass5xx, agent.ErrClassOther} {
+			fmt.Fprintf(w, "dkb_errors_total{%s,class=%q} %d\n", tm.labels(), class, tm.Stats.ErrorsBy[class])
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP dkb_thread_requests_total Requests sent by each amplifier thread.\n# TYPE dkb_thread_requests_total counter\n")
+	for _, tm := range tms {
+		for i, c := range tm.Stats.Threads {
+			fmt.Fprintf(w, "dkb_thread_requests_total{%s,thread=\"%d\"} %d\n", tm.labels(), i+1, c)
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP dkb_request_duration_seconds Latency of requests sent.\n# TYPE dkb_request_duration_seconds histogram\n")
+	for _, tm := range tms {
+		var (
+			h          = tm.Stats.Latency
+			cumulative int64
+		)
+		for i, le := range agent.LatencyBuckets {
+			cumulative += h.Counts[i]
+			fmt.Fprintf(w, "dkb_request_duration_seconds_bucket{%s,le=%q} %d\n", tm.labels(), strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "dkb_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", tm.labels(), h.Count)
+		fmt.Fprintf(w, "dkb_request_duration_seconds_sum{%s} %g\n", tm.labels(), float64(h.SumNanos)/1e9)
+		fmt.Fprintf(w, "dkb_request_duration_seconds_count{%s} %d\n", tm.labels(), h.Count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (tm *TaskMetrics) labels() string {
+	return fmt.Sprintf(`task="%s",tracer="%s"`, labelEscaper.Replace(tm.Task), labelEscaper.Replace(tm.Tracer))
+}
@@ -0,0 +1,62 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	stats := agent.NewStats()
+	stats.Requests, stats.Spans, stats.Planned = 3, 21, 4
+	stats.Threads = []int64{2, 1}
+	stats.Latency.Observe(3e6)
+	stats.Latency.Observe(2e9)
+
+	reg := NewMetricsRegistry()
+	reg.Register(`dd"v0.4`, DDTrace, stats)
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer resp.Body.Close()
+	bts, _ := io.ReadAll(resp.Body)
+	body := string(bts)
+
+	for _, line := range []string{
+		`dkb_requests_total{task="dd\"v0.4",tracer="ddtrace"} 3`,
+		`dkb_spans_total{task="dd\"v0.4",tracer="ddtrace"} 21`,
+		`dkb_thread_requests_total{task="dd\"v0.4",tracer="ddtrace",thread="2"} 1`,
+		`dkb_errors_total{task="dd\"v0.4",tracer="ddtrace",class="5xx"} 0`,
+		`dkb_request_duration_seconds_bucket{task="dd\"v0.4",tracer="ddtrace",le="0.005"} 1`,
+		`dkb_request_duration_seconds_bucket{task="dd\"v0.4",tracer="ddtrace",le="2.5"} 2`,
+		`dkb_request_duration_seconds_count{task="dd\"v0.4",tracer="ddtrace"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("metric line not found: %s\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MonitorConfig selects the process sampled from /proc during task, normally the collector
+type MonitorConfig struct {
+	PID      int    `json:"pid,omitempty"`
+	Process  string `json:"process,omitempty"`
+	Interval string `json:"interval,omitempty"`
+}
+
+const (
+	defMonitorInterval = time.Second
+	// USER_HZ exported by Linux kernel to user space for all architectures in practice
+	clockTicksPerSecond = 100
+)
+
+type ProcessSample struct {
+	Time       time.Time `json:"time"`
+	CPUSeconds float64   `json:"cpu_seconds"`
+	CPUCores   float64   `json:"cpu_cores"`
+	RSSBytes   uint64    `json:"rss_bytes"`
+	FDs        int       `json:"fds"`
+	Threads    int       `json:"threads"`
+	NetRxBytes uint64    `json:"net_rx_bytes"`
+	NetTxBytes uint64    `json:"net_tx_bytes"`
+}
+
+// MonitorReport summarizes samples of task, CPU and network figures are consumed during task,
+// network bytes are counted for the whole network namespace of process
+type MonitorReport struct {
+	PID          int              `json:"pid"`
+	Process      string           `json:"process"`
+	CPUSeconds   float64          `json:"cpu_seconds"`
+	AvgCPUCores  float64          `json:"avg_cpu_cores"`
+	PeakCPUCores float64          `json:"peak_cpu_cores"`
+	AvgRSSBytes  uint64           `json:"avg_rss_bytes"`
+	PeakRSSBytes uint64           `json:"peak_rss_bytes"`
+	PeakFDs      int              `json:"peak_fds"`
+	PeakThreads  int              `json:"peak_threads"`
+	NetRxBytes   uint64           `json:"net_rx_bytes"`
+	NetTxBytes   uint64           `json:"net_tx_bytes"`
+	Samples      []*ProcessSample `json:"samples"`
+}
+
+func (rpt *MonitorReport) Print() {
+	log.Printf("Monitor: pid: %d process: %s samples: %d", rpt.PID, rpt.Process, len(rpt.Samples))
+	log.Printf("CPU: %.2fs avg: %.2f cores peak: %.2f cores", rpt.CPUSeconds, rpt.AvgCPUCores, rpt.PeakCPUCores)
+	log.Printf("RSS: avg: %d bytes peak: %d bytes", rpt.AvgRSSBytes, rpt.PeakRSSBytes)
+	log.Printf("FDs peak: %d Threads peak: %d", rpt.PeakFDs, rpt.PeakThreads)
+	log.Printf("Network: rx: %d bytes tx: %d bytes", rpt.NetRxBytes, rpt.NetTxBytes)
+}
+
+type processMonitor struct {
+	pid      int
+	process  string
+	interval time.Duration
+	samples  []*ProcessSample
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// startProcessMonitor takes the first sample immediately and then one per interval until stopped
+func startProcessMonitor(mconf *MonitorConfig) (*processMonitor, error) {
+	var (
+		pid = mconf.PID
+		err error
+	)
+	if pid == 0 {
+		if mconf.Process == "" {
+			return nil, fmt.Errorf("process ID or name required by monitor")
+		}
+		if pid, err = findProcessByName(mconf.Process); err != nil {
+			return nil, err
+		}
+	}
+	interval := defMonitorInterval
+	if mconf.Interval != "" {
+		if interval, err = time.ParseDuration(mconf.Interval); err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("invalid monitor interval: %s", mconf.Interval)
+		}
+	}
+
+	m := &processMonitor{
+		pid:      pid,
+		process:  readProcessName(pid),
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	first, err := sampleProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	m.samples = append(m.samples, first)
+	go m.run()
+
+	return m, nil
+}
+
+func (m *processMonitor) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			m.sample()
+
+			return
+		case <-ticker.C:
+			m.sample()
+		}
+	}
+}
+
+func (m *processMonitor) sample() {
+	s, err := sampleProcess(m.pid)
+	if err != nil {
+		log.Printf("monitor: %s", err.Error())
+
+		return
+	}
+	prev := m.samples[len(m.samples)-1]
+	if elapsed := s.Time.Sub(prev.Time).Seconds(); elapsed > 0 && s.CPUSeconds >= prev.CPUSeconds {
+		s.CPUCores = (s.CPUSeconds - prev.CPUSeconds) / elapsed
+	}
+	m.samples = append(m.samples, s)
+}
+
+// Stop takes the last sample and summarizes all of them
+func (m *processMonitor) Stop() *MonitorReport {
+	close(m.stop)
+	<-m.done
+
+	var (
+		first = m.samples[0]
+		last  = m.samples[len(m.samples)-1]
+		rpt   = &MonitorReport{
+			PID:        m.pid,
+			Process:    m.process,
+			CPUSeconds: last.CPUSeconds - first.CPUSeconds,
+			NetRxBytes: counterDelta(first.NetRxBytes, last.NetRxBytes),
+			NetTxBytes: counterDelta(first.NetTxBytes, last.NetTxBytes),
+			Samples:    m.samples,
+		}
+		rssSum uint64
+	)
+	if rpt.CPUSeconds < 0 {
+		rpt.CPUSeconds = 0
+	}
+	if elapsed := last.Time.Sub(first.Time).Seconds(); elapsed > 0 {
+		rpt.AvgCPUCores = rpt.CPUSeconds / elapsed
+	}
+	for _, s := range m.samples {
+		rssSum += s.RSSBytes
+		if s.CPUCores > rpt.PeakCPUCores {
+			rpt.PeakCPUCores = s.CPUCores
+		}
+		if s.RSSBytes > rpt.PeakRSSBytes {
+			rpt.PeakRSSBytes = s.RSSBytes
+		}
+		if s.FDs > rpt.PeakFDs {
+			rpt.PeakFDs = s.FDs
+		}
+		if s.Threads > rpt.PeakThreads {
+			rpt.PeakThreads = s.Threads
+		}
+	}
+	rpt.AvgRSSBytes = rssSum / uint64(len(m.samples))
+
+	return rpt
+}
+
+// counterDelta returns the growth of a counter from before to after, 0 if the counter was
+// reset or the target process restarted in between
+func counterDelta(before, after uint64) uint64 {
+	if after < before {
+		return 0
+	}
+
+	return after - before
+}
+
+func findProcessByName(name string) (int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+
+	found := 0
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if readProcessName(pid) == name && (found == 0 || pid < found) {
+			found = pid
+		}
+	}
+	if found == 0 {
+		return 0, fmt.Errorf("process %s not found", name)
+	}
+
+	return found, nil
+}
+
+func readProcessName(pid int) string {
+	bts, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(bts))
+}
+
+func sampleProcess(pid int) (*ProcessSample, error) {
+	now := time.Now()
+	bts, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// process name in field 2 may contain spaces, fields are counted after its closing parenthesis
+	stat := string(bts)
+	if i := strings.LastIndexByte(stat, ')'); i < 0 {
+		return nil, fmt.Errorf("malformed stat of process %d", pid)
+	} else {
+		stat = stat[i+1:]
+	}
+	fields := strings.Fields(stat)
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("malformed stat of process %d", pid)
+	}
+
+	var (
+		utime, _   = strconv.ParseUint(fields[11], 10, 64)
+		stime, _   = strconv.ParseUint(fields[12], 10, 64)
+		threads, _ = strconv.Atoi(fields[17])
+		rss, _     = strconv.ParseUint(fields[21], 10, 64)
+		s          = &ProcessSample{
+			Time:       now,
+			CPUSeconds: float64(utime+stime) / clockTicksPerSecond,
+			RSSBytes:   rss * uint64(os.Getpagesize()),
+			Threads:    threads,
+		}
+	)
+	if fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid)); err == nil {
+		s.FDs = len(fds)
+	}
+	s.NetRxBytes, s.NetTxBytes = readNetDev(filepath.Join("/proc", strconv.Itoa(pid), "net", "dev"))
+
+	return s, nil
+}
+
+// readNetDev sums received and transmitted bytes of all interfaces including loopback
+func readNetDev(path string) (rx, tx uint64) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(bts), "\n") {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(line[i+1:])
+		if len(fields) < 9 {
+			continue
+		}
+		r, _ := strconv.ParseUint(fields[0], 10, 64)
+		t, _ := strconv.ParseUint(fields[8], 10, 64)
+		rx += r
+		tx += t
+	}
+
+	return
+}
@@ -0,0 +1,69 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestProcessMonitor(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process monitor reads /proc")
+	}
+
+	mon, err := startProcessMonitor(&MonitorConfig{PID: os.Getpid(), Interval: "20ms"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// burn some CPU time
+	for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+	}
+	rpt := mon.Stop()
+
+	if len(rpt.Samples) < 3 {
+		t.Fatalf("expect samples taken per interval, got %d", len(rpt.Samples))
+	}
+	if rpt.CPUSeconds <= 0 || rpt.PeakRSSBytes == 0 || rpt.PeakThreads == 0 || rpt.PeakFDs == 0 {
+		t.Fatalf("unexpected report: %+v", rpt)
+	}
+
+	if _, err = startProcessMonitor(&MonitorConfig{Process: "dkb-no-such-process"}); err == nil {
+		t.Fatal("expect error for missing process")
+	}
+}
+
+func TestMonitorCounterReset(t *testing.T) {
+	now := time.Now()
+	mon := &processMonitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		samples: []*ProcessSample{
+			{Time: now, CPUSeconds: 10, NetRxBytes: 1000, NetTxBytes: 100},
+			// the target process restarted, its counters start over
+			{Time: now.Add(time.Second), CPUSeconds: 1, NetRxBytes: 10, NetTxBytes: 200},
+		},
+	}
+	close(mon.done)
+	rpt := mon.Stop()
+	if rpt.CPUSeconds != 0 || rpt.NetRxBytes != 0 || rpt.NetTxBytes != 100 {
+		t.Fatalf("unexpected deltas after counter reset: %+v", rpt)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+)
+
+// CPUBudget bounds cores used by tasks running together on local host, each sender thread
+// of a task counts as one core. It's safe for concurrent use.
+type CPUBudget struct {
+	sync.Mutex
+	total, used int
+	// closed and replaced whenever cores are released
+	released chan struct{}
+}
+
+// NewCPUBudget returns budget of cores, all cores of local host if cores is not positive
+func NewCPUBudget(cores int) *CPUBudget {
+	if cores <= 0 {
+		cores = runtime.NumCPU()
+	}
+
+	return &CPUBudget{total: cores, released: make(chan struct{})}
+}
+
+// acquire waits for n cores, n is capped by the whole budget so that any task can run
+func (b *CPUBudget) acquire(ctx context.Context, n int) (int, error) {
+	if n > b.total {
+		n = b.total
+	}
+	for {
+		b.Lock()
+		if b.used+n <= b.total {
+			b.used += n
+			b.Unlock()
+
+			return n, nil
+		}
+		released := b.released
+		b.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+func (b *CPUBudget) release(n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.used -= n
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// cpuCost returns cores task takes on local host, a coordinator only waits for workers
+func (tkconf *TaskConfig) cpuCost() int {
+	if len(tkconf.Workers) != 0 || tkconf.SendThreads < 1 {
+		return 1
+	}
+
+	return tkconf.SendThreads
+}
+
+// PlanTasks splits tasks into units run one after another. With parallel, tasks sharing
+// parallel_group form one unit at the position of the first of them, otherwise every task
+// is a unit on its own.
+func PlanTasks(tasks []*TaskConfig, parallel bool) [][]*TaskConfig {
+	var (
+		units [][]*TaskConfig
+		group = make(map[string]int)
+	)
+	for _, task := range tasks {
+		if !parallel || task.ParallelGroup == "" {
+			units = append(units, []*TaskConfig{task})
+			continue
+		}
+		if i, ok := group[task.ParallelGroup]; ok {
+			units[i] = append(units[i], task)
+		} else {
+			group[task.ParallelGroup] = len(units)
+			units = append(units, []*TaskConfig{task})
+		}
+	}
+
+	return units
+}
+
+// RunGroup runs tasks at the same time within budget and returns their results in order.
+// Results of more than one task are tagged by the window they overlapped.
+func (r *Runner) RunGroup(ctx context.Context, tasks []*TaskConfig, budget *CPUBudget, extra ...agent.AmplifierOption) []*Result {
+	var (
+		results = make([]*Result, len(tasks))
+		wg      sync.WaitGroup
+	)
+	for i, task := range tasks {
+		wg.Add(1)
+		go func(i int, task *TaskConfig) {
+			defer wg.Done()
+
+			if budget != nil {
+				// Run reports cancellation if budget is never granted
+				if n, err := budget.acquire(ctx, task.cpuCost()); err == nil {
+					defer budget.release(n)
+				}
+			}
+			results[i], _ = r.Run(ctx, task, extra...)
+		}(i, task)
+	}
+	wg.Wait()
+
+	if len(tasks) > 1 {
+		tagOverlap(tasks[0].ParallelGroup, results)
+	}
+
+	return results
+}
+
+// tagOverlap sets Overlap of results run in group
+func tagOverlap(group string, results []*Result) {
+	var start, end time.Time
+	for i, res := range results {
+		if i == 0 || res.Start.After(start) {
+			start = res.Start
+		}
+		if i == 0 || res.End.Before(end) {
+			end = res.End
+		}
+	}
+	if !start.Before(end) {
+		// budget never let all of them run together
+		start, end = time.Time{}, time.Time{}
+	}
+
+	for _, res := range results {
+		ov := &Overlap{Group: group, Start: start, End: end}
+		for _, other := range results {
+			if other != res && other.Start.Before(res.End) && res.Start.Before(other.End) {
+				ov.With = append(ov.With, other.Name)
+			}
+		}
+		sort.Strings(ov.With)
+		res.Overlap = ov
+	}
+}
+
+// Overlap tells tasks of parallel group running at the same time as the task
+type Overlap struct {
+	Group string   `json:"group"`
+	With  []string `json:"with,omitempty"`
+	// window all tasks of group ran together, zero if they never did
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end,omitempty"`
+}
+
+func (ov *Overlap) String() string {
+	s := "group: " + ov.Group
+	if len(ov.With) != 0 {
+		s += " with: " + strings.Join(ov.With, ", ")
+	} else {
+		s += " ran alone within cpu budget"
+	}
+	if !ov.Start.IsZero() {
+		s += " all together: " + ov.End.Sub(ov.Start).String()
+	}
+
+	return s
+}
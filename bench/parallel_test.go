@@ -0,0 +1,105 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlanTasks(t *testing.T) {
+	tasks := []*TaskConfig{
+		NewTaskConfig(TracerWithName("a"), TracerWithParallelGroup("mixed")),
+		NewTaskConfig(TracerWithName("b")),
+		NewTaskConfig(TracerWithName("c"), TracerWithParallelGroup("mixed")),
+	}
+	var got [][]string
+	for _, unit := range PlanTasks(tasks, true) {
+		got = append(got, taskNames(unit))
+	}
+	if want := [][]string{{"a", "c"}, {"b"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expect units %v got %v", want, got)
+	}
+	if units := PlanTasks(tasks, false); len(units) != 3 {
+		t.Fatalf("expect 3 units without parallel got %d", len(units))
+	}
+}
+
+func TestRunGroup(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	archive := newTestArchive(t)
+	newTasks := func() []*TaskConfig {
+		var tasks []*TaskConfig
+		for _, name := range []string{"dd", "jg"} {
+			tasks = append(tasks, NewTaskConfig(
+				TracerWithName(name),
+				TracerWithArchive(archive),
+				TracerWithAmplifier(1, 5),
+				TracerWithParallelGroup("mixed"),
+				withTestCollector(collector),
+			))
+		}
+
+		return tasks
+	}
+
+	results := NewRunner().RunGroup(context.TODO(), newTasks(), NewCPUBudget(2))
+	for i, res := range results {
+		if res.Error != "" || res.Sent.Requests != 5 {
+			t.Fatalf("unexpected result: %+v", res)
+		}
+		other := results[1-i].Name
+		if ov := res.Overlap; ov == nil || ov.Group != "mixed" || !reflect.DeepEqual(ov.With, []string{other}) || !ov.Start.Before(ov.End) {
+			t.Fatalf("task: %s unexpected overlap: %+v", res.Name, res.Overlap)
+		}
+	}
+
+	// one core lets one task run at a time
+	results = NewRunner().RunGroup(context.TODO(), newTasks(), NewCPUBudget(1))
+	for _, res := range results {
+		if ov := res.Overlap; ov == nil || len(ov.With) != 0 || !ov.Start.IsZero() {
+			t.Fatalf("task: %s expect no overlap got %+v", res.Name, res.Overlap)
+		}
+	}
+}
+
+func TestCPUBudgetCancel(t *testing.T) {
+	budget := NewCPUBudget(2)
+	n, err := budget.acquire(context.TODO(), 4)
+	if err != nil || n != 2 {
+		t.Fatalf("expect cost capped to 2 got %d %v", n, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = budget.acquire(ctx, 1); err == nil {
+		t.Fatal("expect acquire canceled")
+	}
+	budget.release(n)
+	if _, err = budget.acquire(context.TODO(), 1); err != nil {
+		t.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,315 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+	"github.com/CodapeWild/dktrace-data-benchmark/fixtures"
+	"github.com/CodapeWild/dktrace-data-benchmark/route"
+	"github.com/CodapeWild/dktrace-data-benchmark/tracer"
+)
+
+// StartFunc captures traces of task and starts amplifying them towards endpoint, finish is
+// closed once amplifier threads are done. opts are built from task by the runner.
+type StartFunc func(ctx context.Context, task *TaskConfig, endpoint string, opts ...agent.AmplifierOption) (canceler context.CancelFunc, finish chan struct{}, err error)
+
+// AgentFunc starts the capture agent listening on agentAddress together with the amplifier
+// sending captured traces to endpoint once expected spans arrived.
+type AgentFunc func(ctx context.Context, agentAddress, endpoint string, expected, threads, repeat int, opts ...agent.AmplifierOption) (canceler context.CancelFunc, finish chan struct{}, err error)
+
+// Protocol describes how tasks with Tracer equal to Name are run. A protocol either spawns
+// routes through NewTracer captured by StartAgent, or starts on its own by Start. Tasks
+// picking one of Versions replay its fixture instead.
+type Protocol struct {
+	Name string
+	// NewTracer returns the tracer library wrapper spawning routes
+	NewTracer func() tracer.Tracer
+	// StartAgent starts the capture agent and the amplifier for traces of NewTracer
+	StartAgent AgentFunc
+	// Start replaces the capture flow, used by protocols without tracer
+	Start StartFunc
+	// Versions of tracer library a task may pick, each one recorded in Fixtures as
+	// <version>.jsonl, tasks without version run NewTracer
+	Versions []string
+	Fixtures fs.FS
+	// CollectorPaths accepted by collector, the first one is used by tasks without collector_path
+	CollectorPaths []string
+	// Check validates protocol specific fields of task
+	Check func(task *TaskConfig) error
+	// UnixAgent is true if the capture agent of StartAgent listens on Unix sockets and
+	// NewTracer sends to them, as required by tasks with agent_socket
+	UnixAgent bool
+
+	// tracer libraries may be process global, tasks running together spawn one by one
+	spawnLock sync.Mutex
+}
+
+func (p *Protocol) check() error {
+	if p.Name == "" {
+		return fmt.Errorf("protocol name required")
+	}
+	if p.Start == nil && (p.NewTracer == nil || p.StartAgent == nil) {
+		return fmt.Errorf("protocol %s: Start or both NewTracer and StartAgent required", p.Name)
+	}
+
+	return nil
+}
+
+// DefaultCollectorPath returns the path used by tasks without collector_path
+func (p *Protocol) DefaultCollectorPath() string {
+	if len(p.CollectorPaths) == 0 {
+		return ""
+	}
+
+	return p.CollectorPaths[0]
+}
+
+// Fixture loads recorded payloads of version
+func (p *Protocol) Fixture(version string) ([]*agent.ArchiveRecord, error) {
+	if !contains(p.Versions, version) || p.Fixtures == nil {
+		return nil, fmt.Errorf("version %s not supported by %s, supported: %v", version, p.Name, p.Versions)
+	}
+	f, err := p.Fixtures.Open(version + ".jsonl")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return agent.ReadArchive(f)
+}
+
+// Recordable reports whether payloads of the protocol can be captured into archive
+func (p *Protocol) Recordable() bool {
+	return p.NewTracer != nil
+}
+
+// Validate reports the first problem found in task against the protocol
+func (p *Protocol) Validate(task *TaskConfig) error {
+	if task.Version != "" {
+		if _, err := p.Fixture(task.Version); err != nil {
+			return fmt.Errorf("task: %s %s", task.Name, err.Error())
+		}
+	} else if p.NewTracer != nil {
+		if _, err := task.loadRoute(); err != nil {
+			return fmt.Errorf("task: %s route: %s", task.Name, err.Error())
+		}
+	}
+	if task.AgentSocket && (!p.UnixAgent || task.Version != "") {
+		return fmt.Errorf("task: %s agent_socket not supported by tracer %s without version", task.Name, p.Name)
+	}
+	if _, err := task.amplifierOptions(); err != nil {
+		return fmt.Errorf("task: %s %s", task.Name, err.Error())
+	}
+	if _, err := p.withDefaults(task).collectorEndpoint(); err != nil {
+		return fmt.Errorf("task: %s %s", task.Name, err.Error())
+	}
+	if p.Check != nil {
+		return p.Check(task)
+	}
+
+	return nil
+}
+
+// withDefaults returns a copy of task with defaults of protocol filled in
+func (p *Protocol) withDefaults(task *TaskConfig) *TaskConfig {
+	cp := *task
+	if cp.CollectorPath == "" {
+		cp.CollectorPath = p.DefaultCollectorPath()
+	}
+
+	return &cp
+}
+
+// start runs task by the protocol, extra options are appended to the ones of task
+func (p *Protocol) start(ctx context.Context, task *TaskConfig, extra ...agent.AmplifierOption) (canceler context.CancelFunc, finish chan struct{}, err error) {
+	task = p.withDefaults(task)
+	opts, err := task.amplifierOptions()
+	if err != nil {
+		return
+	}
+	opts = append(opts, extra...)
+	endpoint, err := task.collectorEndpoint()
+	if err != nil {
+		return
+	}
+	if task.Version != "" {
+		var records []*agent.ArchiveRecord
+		if records, err = p.Fixture(task.Version); err != nil {
+			return
+		}
+
+		return agent.StartReplay(ctx, records, endpoint, task.SendThreads, task.SendTimesPerThread, opts...)
+	}
+	if p.Start != nil {
+		return p.Start(ctx, task, endpoint, opts...)
+	}
+
+	var r route.Route
+	if r, err = task.loadRoute(); err != nil {
+		return
+	}
+	tr := r.CreateTree(p.NewTracer())
+	agentAddress := newRandomPortWithLocalHost()
+	if task.AgentSocket {
+		if !p.UnixAgent {
+			err = fmt.Errorf("agent_socket not supported by tracer: %s", p.Name)
+
+			return
+		}
+		agentAddress = newUnixSocketAddress()
+	}
+	canceler, finish, err = p.StartAgent(ctx, agentAddress, endpoint, tr.Count(), task.SendThreads, task.SendTimesPerThread, opts...)
+	if err != nil {
+		return
+	}
+	if path, ok := agent.ParseUnixAddress(agentAddress); ok {
+		// the capture agent shuts down in background, the socket file must not outlive the process
+		cancel := canceler
+		canceler = func() {
+			cancel()
+			os.Remove(path)
+		}
+	}
+	p.spawnLock.Lock()
+	defer p.spawnLock.Unlock()
+	tr.Spawn(ctx, agentAddress)
+
+	return
+}
+
+var (
+	protocolsLock sync.RWMutex
+	protocols     = make(map[string]*Protocol)
+)
+
+// RegisterProtocol makes protocol available to tasks, registering a name again replaces it.
+// Protocols of other packages are usually registered in their init functions.
+func RegisterProtocol(p *Protocol) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+
+	protocols[p.Name] = p
+
+	return nil
+}
+
+// LookupProtocol returns the protocol registered as name
+func LookupProtocol(name string) (*Protocol, bool) {
+	protocolsLock.RLock()
+	defer protocolsLock.RUnlock()
+
+	p, ok := protocols[name]
+
+	return p, ok
+}
+
+// Protocols returns registered protocols ordered by name
+func Protocols() []*Protocol {
+	protocolsLock.RLock()
+	defer protocolsLock.RUnlock()
+
+	var ps []*Protocol
+	for _, p := range protocols {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Name < ps[j].Name })
+
+	return ps
+}
+
+// Validate checks task against its protocol without running it
+func Validate(task *TaskConfig) error {
+	if task.Name == "" {
+		return fmt.Errorf("task name required")
+	}
+	p, ok := LookupProtocol(task.Tracer)
+	if !ok {
+		return fmt.Errorf("task: %s unrecognized tracer: %s", task.Name, task.Tracer)
+	}
+
+	return p.Validate(task)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func replayArchive(ctx context.Context, task *TaskConfig, endpoint string, opts ...agent.AmplifierOption) (context.CancelFunc, chan struct{}, error) {
+	records, err := agent.LoadArchive(task.Archive)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return agent.StartReplay(ctx, records, endpoint, task.SendThreads, task.SendTimesPerThread, opts...)
+}
+
+func checkArchive(task *TaskConfig) error {
+	if _, err := os.Stat(task.Archive); err != nil {
+		return fmt.Errorf("task: %s archive: %s", task.Name, err.Error())
+	}
+
+	return nil
+}
+
+func init() {
+	for _, p := range []*Protocol{
+		{
+			Name:           DDTrace,
+			NewTracer:      func() tracer.Tracer { return &tracer.DDTracerWrapper{} },
+			StartAgent:     agent.StartDDAgent,
+			Versions:       []string{"v1", "v2"},
+			Fixtures:       fixtures.Protocol(DDTrace),
+			CollectorPaths: []string{"/v0.4/traces"},
+			UnixAgent:      true,
+		},
+		{
+			Name:           Jaeger,
+			NewTracer:      func() tracer.Tracer { return &tracer.JgTracerWrapper{} },
+			StartAgent:     agent.StartJgAgent,
+			Versions:       []string{"client-go", "otel"},
+			Fixtures:       fixtures.Protocol(Jaeger),
+			CollectorPaths: []string{"/apis/traces"},
+		},
+		{
+			Name:  Replay,
+			Start: replayArchive,
+			Check: checkArchive,
+		},
+	} {
+		if err := RegisterProtocol(p); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	route := TracerWithRoute("../routes/user-login.json")
+	cases := []struct {
+		task *TaskConfig
+		ok   bool
+	}{
+		{NewTaskConfig(TracerWithName("dd"), TracerWithTracer(DDTrace), route), true},
+		{NewTaskConfig(TracerWithName("dd-v1"), TracerWithTracer(DDTrace), TracerWithVersion("v1"), route), true},
+		{NewTaskConfig(TracerWithName("dd-v9"), TracerWithTracer(DDTrace), TracerWithVersion("v9"), route), false},
+		{NewTaskConfig(TracerWithName("jg-client-go"), TracerWithTracer(Jaeger), TracerWithVersion("client-go")), true},
+		{NewTaskConfig(TracerWithName("jg-otel"), TracerWithTracer(Jaeger), TracerWithVersion("otel")), true},
+		{NewTaskConfig(TracerWithName("replay-v1"), TracerWithArchive(newTestArchive(t)), TracerWithVersion("v1")), false},
+		{NewTaskConfig(TracerWithName("no-route"), TracerWithTracer(Jaeger), TracerWithRoute("./not-exist.json")), false},
+		{NewTaskConfig(TracerWithName("unknown"), TracerWithTracer("unknown"), route), false},
+		{NewTaskConfig(TracerWithTracer(DDTrace), route), false},
+		{NewTaskConfig(TracerWithName("replay"), TracerWithArchive(newTestArchive(t))), true},
+		{NewTaskConfig(TracerWithName("no-archive"), TracerWithArchive(filepath.Join(t.TempDir(), "none.jsonl"))), false},
+		{NewTaskConfig(TracerWithName("dd-unix"), TracerWithTracer(DDTrace), TracerWithAgentSocket(true), TracerWithCollectorSocket("/tmp/dk.sock", ""), route), true},
+		{NewTaskConfig(TracerWithName("dd-v1-unix"), TracerWithTracer(DDTrace), TracerWithVersion("v1"), TracerWithAgentSocket(true)), false},
+		{NewTaskConfig(TracerWithName("jg-unix"), TracerWithTracer(Jaeger), TracerWithAgentSocket(true), route), false},
+		{NewTaskConfig(TracerWithName("no-socket"), TracerWithTracer(DDTrace), TracerWithCollectorSocket("", ""), route), false},
+		{NewTaskConfig(TracerWithName("retry"), TracerWithTracer(DDTrace), TracerWithRetry(&RetryConfig{MaxAttempts: 3, Backoff: "100ms"}, &QueueConfig{Size: 10, Interval: "1s"}), route), true},
+		{NewTaskConfig(TracerWithName("no-attempts"), TracerWithTracer(DDTrace), TracerWithRetry(&RetryConfig{Backoff: "100ms"}, nil), route), false},
+		{NewTaskConfig(TracerWithName("no-queue"), TracerWithTracer(DDTrace), TracerWithRetry(nil, &QueueConfig{}), route), false},
+		{NewTaskConfig(TracerWithName("no-interval"), TracerWithTracer(DDTrace), TracerWithRetry(nil, &QueueConfig{Size: 10}), route), false},
+	}
+	for _, c := range cases {
+		if err := Validate(c.task); (err == nil) != c.ok {
+			t.Errorf("task: %s expect ok %v got error %v", c.task.Name, c.ok, err)
+		}
+	}
+}
+
+func TestProtocolDefaults(t *testing.T) {
+	proto, ok := LookupProtocol(Jaeger)
+	if !ok {
+		t.Fatal("jaeger not registered")
+	}
+	task := proto.withDefaults(NewTaskConfig(TracerWithName("jg"), TracerWithTracer(Jaeger)))
+	if task.CollectorPath != "/apis/traces" {
+		t.Fatalf("unexpected default path: %s", task.CollectorPath)
+	}
+	if err := RegisterProtocol(&Protocol{Name: "incomplete"}); err == nil {
+		t.Fatal("expect error registering protocol without start")
+	}
+}
+
+func TestRunFixtureVersions(t *testing.T) {
+	var paths sync.Map
+	collector := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		paths.Store(req.URL.Path, true)
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	for _, proto := range []*Protocol{mustLookupProtocol(t, DDTrace), mustLookupProtocol(t, Jaeger)} {
+		for _, version := range proto.Versions {
+			task := NewTaskConfig(
+				TracerWithName(proto.Name+"-"+version),
+				TracerWithTracer(proto.Name),
+				TracerWithVersion(version),
+				TracerWithAmplifier(2, 3),
+				withTestCollector(collector),
+				func(tkconf *TaskConfig) { tkconf.CollectorPath = "" },
+			)
+			res, err := NewRunner().Run(context.TODO(), task)
+			if err != nil {
+				t.Fatalf("task: %s %s", task.Name, err.Error())
+			}
+			if res.Version != version || res.Sent.Requests != 6 || res.Sent.Spans != 6*7 {
+				t.Fatalf("task: %s unexpected result, version: %s requests: %d spans: %d", task.Name, res.Version, res.Sent.Requests, res.Sent.Spans)
+			}
+		}
+		if _, ok := paths.Load(proto.DefaultCollectorPath()); !ok {
+			t.Fatalf("protocol: %s default path %s not requested", proto.Name, proto.DefaultCollectorPath())
+		}
+	}
+}
+
+func mustLookupProtocol(t *testing.T, name string) *Protocol {
+	proto, ok := LookupProtocol(name)
+	if !ok {
+		t.Fatalf("protocol: %s not registered", name)
+	}
+
+	return proto
+}
+
+func TestRunUnixSockets(t *testing.T) {
+	var requests int64
+	socket := filepath.Join(t.TempDir(), "collector.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	collector := &http.Server{Handler: http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/v0.4/traces" {
+			atomic.AddInt64(&requests, 1)
+		}
+		resp.WriteHeader(http.StatusOK)
+	})}
+	go collector.Serve(l)
+	defer collector.Close()
+
+	task := NewTaskConfig(
+		TracerWithName("dd-unix"),
+		TracerWithTracer(DDTrace),
+		TracerWithRoute("../routes/user-login.json"),
+		TracerWithAmplifier(2, 3),
+		TracerWithAgentSocket(true),
+		TracerWithCollectorSocket(socket, ""),
+	)
+	res, err := NewRunner().Run(context.TODO(), task)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if res.Sent.Requests != 6 || res.Sent.Errors != 0 || atomic.LoadInt64(&requests) != 6 {
+		t.Fatalf("unexpected result over unix sockets: %+v collector requests: %d", res.Sent, atomic.LoadInt64(&requests))
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+)
+
+type Result struct {
+	Name   string `json:"name"`
+	Tracer string `json:"tracer"`
+	// tracer version replayed from fixture, empty if the tracer ran
+	Version string    `json:"version,omitempty"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Error   string    `json:"error,omitempty"`
+	// canceled or timed out before all requests sent
+	Partial bool           `json:"partial,omitempty"`
+	Warning string         `json:"warning,omitempty"`
+	Capture *agent.Capture `json:"capture,omitempty"`
+	Sent    *agent.Stats   `json:"sent,omitempty"`
+	Monitor *MonitorReport `json:"monitor,omitempty"`
+	// collector self-metrics
+	Collector *CollectorMetricsReport `json:"collector,omitempty"`
+	// tasks of parallel group running at the same time
+	Overlap *Overlap `json:"overlap,omitempty"`
+	// results reported by workers, merged into Sent
+	Worker  string    `json:"worker,omitempty"`
+	Workers []*Result `json:"workers,omitempty"`
+}
+
+// warn appends warning to the ones already kept in res
+func (res *Result) warn(warning string) {
+	log.Printf("Warning: %s", warning)
+	if res.Warning != "" {
+		res.Warning += "; "
+	}
+	res.Warning += warning
+}
+
+func (res *Result) Print() {
+	log.Println("------")
+	if res.Version != "" {
+		log.Printf("Task: %s Tracer: %s Version: %s", res.Name, res.Tracer, res.Version)
+	} else {
+		log.Printf("Task: %s Tracer: %s", res.Name, res.Tracer)
+	}
+	log.Printf("Duration: %s", res.End.Sub(res.Start))
+	if res.Error != "" {
+		log.Printf("Error: %s", res.Error)
+	}
+	if res.Partial {
+		log.Println("Partial: canceled before all requests sent")
+	}
+	if res.Warning != "" {
+		log.Printf("Warning: %s", res.Warning)
+	}
+	if res.Overlap != nil {
+		log.Printf("Overlap: %s", res.Overlap)
+	}
+	if res.Capture != nil {
+		log.Printf("Captured: %d of %d spans expected", res.Capture.Captured, res.Capture.Expected)
+	}
+	if res.Sent != nil {
+		log.Printf("Sent: requests: %d spans: %d bytes: %d errors: %d", res.Sent.Requests, res.Sent.Spans, res.Sent.Bytes, res.Sent.Errors)
+		log.Printf("Connections: dials: %d reuses: %d", res.Sent.Dials, res.Sent.Reuses)
+		if res.Sent.Retries != 0 || res.Sent.Dropped != 0 || res.Sent.QueueDropped != 0 {
+			log.Printf("Retries: %d Dropped: after attempts: %d queue full: %d spans: %d", res.Sent.Retries, res.Sent.Dropped, res.Sent.QueueDropped, res.Sent.DroppedSpans)
+		}
+		if res.Sent.Warmup != 0 {
+			log.Printf("Warm-up: requests: %d spans: %d excluded", res.Sent.Warmup, res.Sent.WarmupSpans)
+		}
+	}
+	if res.Monitor != nil {
+		res.Monitor.Print()
+	}
+	if res.Collector != nil {
+		res.Collector.Print()
+	}
+	for _, wres := range res.Workers {
+		if wres.Sent != nil {
+			log.Printf("Worker: %s requests: %d spans: %d errors: %d", wres.Worker, wres.Sent.Requests, wres.Sent.Spans, wres.Sent.Errors)
+		}
+		if wres.Error != "" {
+			log.Printf("Worker: %s Error: %s", wres.Worker, wres.Error)
+		}
+	}
+}
+
+// DumpResults writes results of a run as JSON, nothing written if path is empty
+func DumpResults(path string, results []*Result) error {
+	if path == "" {
+		return nil
+	}
+
+	bts, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, bts, 0644)
+}
@@ -0,0 +1,248 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+// Package bench runs benchmark tasks: traces generated by a tracer along a route, or loaded
+// from an archive, are captured and amplified towards the collector.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+)
+
+// defDrainTimeout bounds the wait for requests in flight once a task is canceled
+var defDrainTimeout = 10 * time.Second
+
+type RunnerOption func(r *Runner)
+
+// RunnerWithMetrics registers stats of every task run into reg.
+func RunnerWithMetrics(reg *MetricsRegistry) RunnerOption {
+	return func(r *Runner) {
+		r.metrics = reg
+	}
+}
+
+// RunnerWithDrainTimeout bounds the wait for requests in flight once a task is canceled.
+func RunnerWithDrainTimeout(timeout time.Duration) RunnerOption {
+	return func(r *Runner) {
+		r.drainTimeout = timeout
+	}
+}
+
+// Runner runs tasks with protocols registered by RegisterProtocol, it's safe for concurrent
+// use but tasks run at the same time compete for local host resources.
+type Runner struct {
+	metrics      *MetricsRegistry
+	drainTimeout time.Duration
+}
+
+func NewRunner(opts ...RunnerOption) *Runner {
+	r := &Runner{drainTimeout: defDrainTimeout}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Run runs task locally, or on its workers if any, extra options are appended to amplifier
+// options of task. Once ctx is done or task timeout reached, sending stops and the result
+// is marked partial. The result is always returned, err is also kept in Result.Error.
+func (r *Runner) Run(ctx context.Context, task *TaskConfig, extra ...agent.AmplifierOption) (res *Result, err error) {
+	var (
+		stats   = agent.NewStats()
+		mon     *processMonitor
+		scraper *metricsScraper
+	)
+	res = &Result{Name: task.Name, Tracer: task.Tracer, Version: task.Version, Start: time.Now()}
+	defer func() {
+		res.End = time.Now()
+		res.Sent = stats.Snapshot()
+		if mon != nil {
+			res.Monitor = mon.Stop()
+		}
+		if scraper != nil {
+			// traffic was sent as planned, only the collector report is missing
+			var serr error
+			if res.Collector, serr = scraper.Stop(res.Sent); serr != nil {
+				res.warn("collector metrics: " + serr.Error())
+			}
+		}
+		if err != nil {
+			log.Println(err.Error())
+			res.Error = err.Error()
+		}
+	}()
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if task.Timeout != "" {
+		var (
+			d      time.Duration
+			cancel context.CancelFunc
+		)
+		if d, err = time.ParseDuration(task.Timeout); err != nil {
+			return
+		}
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	if r.metrics != nil {
+		r.metrics.Register(task.Name, task.Tracer, stats)
+	}
+	if task.Monitor != nil {
+		if mon, err = startProcessMonitor(task.Monitor); err != nil {
+			return
+		}
+	}
+	if task.CollectorMetricsURL != "" {
+		if scraper, err = startMetricsScraper(task); err != nil {
+			return
+		}
+	}
+
+	if len(task.Workers) != 0 {
+		res.Workers, err = distributeTask(ctx, task, stats)
+		res.Partial = ctx.Err() != nil
+
+		return
+	}
+
+	proto, ok := LookupProtocol(task.Tracer)
+	if !ok {
+		err = fmt.Errorf("unrecognized task, Name: %s Tracer %s", task.Name, task.Tracer)
+
+		return
+	}
+	var (
+		capture = &agent.Capture{}
+		opts    = append([]agent.AmplifierOption{agent.WithStats(stats), agent.WithCapture(capture)}, extra...)
+	)
+	canceler, finish, err := proto.start(ctx, task, opts...)
+	if canceler != nil {
+		defer canceler()
+	}
+	if err != nil {
+		return
+	}
+	if finish != nil {
+		select {
+		case <-finish:
+		case <-ctx.Done():
+			log.Printf("task: %s %s, draining requests in flight", task.Name, ctx.Err())
+			select {
+			case <-finish:
+			case <-time.After(r.drainTimeout):
+				log.Printf("task: %s not drained in %s", task.Name, r.drainTimeout)
+			}
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		res.Partial = true
+	}
+	if res.Capture = capture.Snapshot(); res.Capture.Expected == 0 {
+		// replayed without capture
+		res.Capture = nil
+	} else if res.Capture.TimedOut && err == nil {
+		res.Warning, err = checkCapture(res.Capture, task.CaptureProceed)
+	}
+
+	return
+}
+
+// checkCapture reports a capture timeout as warning if task proceeds with spans captured,
+// otherwise as error
+func checkCapture(capture *agent.Capture, proceed bool) (string, error) {
+	if !capture.TimedOut {
+		return "", nil
+	}
+	if proceed && capture.Captured > 0 {
+		return fmt.Sprintf("capture timeout: amplified %d of %d spans expected", capture.Captured, capture.Expected), nil
+	}
+
+	return "", fmt.Errorf("capture timeout: %d of %d spans captured", capture.Captured, capture.Expected)
+}
+
+// Record runs the route of task through its tracer and saves captured requests into
+// archive without amplifying them
+func (r *Runner) Record(ctx context.Context, task *TaskConfig, archivePath string) error {
+	proto, ok := LookupProtocol(task.Tracer)
+	if !ok || !proto.Recordable() {
+		return fmt.Errorf("record not supported by tracer: %s", task.Tracer)
+	}
+	if task.Version != "" {
+		return fmt.Errorf("record runs the tracer, version %s is replayed from fixture", task.Version)
+	}
+
+	archive, err := agent.NewArchiveWriter(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	var (
+		rec     = *task
+		capture = &agent.Capture{}
+	)
+	rec.SendThreads = 0
+	canceler, finish, err := proto.start(ctx, &rec, agent.WithArchive(archive), agent.WithCapture(capture))
+	if canceler != nil {
+		defer canceler()
+	}
+	if err != nil {
+		return err
+	}
+	<-finish
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	// captured requests are archived as they arrive, a partial capture is kept on proceed
+	warning, err := checkCapture(capture.Snapshot(), rec.CaptureProceed)
+	if warning != "" {
+		log.Println(warning)
+	}
+
+	return err
+}
+
+// newRandomPortWithLocalHost returns a local address on a port free at the moment, so that
+// tasks running together don't collide, or a random port ranging from 6000 to 9000
+func newRandomPortWithLocalHost() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Sprintf("127.0.0.1:%d", rand.Intn(3000)+6000)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+// newUnixSocketAddress returns the agent address of a Unix socket in temporary directory,
+// the socket file is removed once the capture agent shuts down
+func newUnixSocketAddress() string {
+	return agent.UnixAddress(filepath.Join(os.TempDir(), fmt.Sprintf("dkb-%d-%d.sock", os.Getpid(), rand.Int31())))
+}
@@ -0,0 +1,103 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+// newTestArchive writes one ddtrace v0.4 payload into an archive for replay
+func newTestArchive(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "dd.jsonl")
+	archive, err := agent.NewArchiveWriter(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer archive.Close()
+
+	body, err := pb.Traces{{&pb.Span{Service: "login", Name: "auth", TraceID: 1, SpanID: 1}}}.MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	header := http.Header{"Content-Type": []string{"application/msgpack"}}
+	if err = archive.Write(&agent.ArchiveRecord{Protocol: agent.ProtocolDDTrace, Pattern: "/v0.4/traces", Header: header, Body: body, Time: time.Now()}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return path
+}
+
+func withTestCollector(collector *httptest.Server) TracerConfigOption {
+	host, port, _ := net.SplitHostPort(collector.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	return TracerWithCollector("http", host, p, "/v0.4/traces")
+}
+
+func TestRunTaskTimeout(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	task := NewTaskConfig(
+		TracerWithName("timeout"),
+		TracerWithArchive(newTestArchive(t)),
+		TracerWithAmplifier(2, 1000),
+		withTestCollector(collector),
+		TracerWithTimeout("300ms"),
+	)
+	runner := NewRunner()
+	res, err := runner.Run(context.TODO(), task)
+	if !res.Partial || err == nil || res.Error != err.Error() {
+		t.Fatalf("expect partial result with error: %+v", res)
+	}
+	if res.Sent.Requests == 0 || res.Sent.Requests >= res.Sent.Planned || res.Sent.InFlight != 0 {
+		t.Fatalf("unexpected stats of partial result: %+v", res.Sent)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res, err = runner.Run(ctx, task); err == nil || res.Sent.Requests != 0 {
+		t.Fatalf("task not skipped after cancel: %+v", res)
+	}
+}
+
+func TestCheckCapture(t *testing.T) {
+	partial := &agent.Capture{Expected: 10, Captured: 4, TimedOut: true}
+	if warning, err := checkCapture(partial, true); warning == "" || err != nil {
+		t.Fatalf("expect warning on proceed, got %q %v", warning, err)
+	}
+	if _, err := checkCapture(partial, false); err == nil {
+		t.Fatal("expect error without proceed")
+	}
+	if _, err := checkCapture(&agent.Capture{Expected: 10, TimedOut: true}, true); err == nil {
+		t.Fatal("expect error with nothing captured")
+	}
+}
@@ -0,0 +1,330 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+)
+
+// MetricsSample holds the value of every selected metric at one scrape
+type MetricsSample struct {
+	Time   time.Time          `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+// CollectorMetricsReport correlates spans sent by amplifier with spans accepted and dropped
+// as reported by collector, ratios are left zero if no span sent
+type CollectorMetricsReport struct {
+	URL           string             `json:"url"`
+	Before        *MetricsSample     `json:"before"`
+	After         *MetricsSample     `json:"after"`
+	Deltas        map[string]float64 `json:"deltas"`
+	Samples       []*MetricsSample   `json:"samples,omitempty"`
+	SentSpans     int64              `json:"sent_spans"`
+	AcceptedSpans float64            `json:"accepted_spans,omitempty"`
+	DroppedSpans  float64            `json:"dropped_spans,omitempty"`
+	AcceptedRatio float64            `json:"accepted_ratio,omitempty"`
+	DroppedRatio  float64            `json:"dropped_ratio,omitempty"`
+}
+
+func (rpt *CollectorMetricsReport) Print() {
+	log.Printf("Collector metrics: %s", rpt.URL)
+	for name, delta := range rpt.Deltas {
+		log.Printf("%s: %g -> %g delta: %g", name, rpt.Before.Values[name], rpt.After.Values[name], delta)
+	}
+	log.Printf("Spans: sent: %d accepted: %g (%.2f%%) dropped: %g (%.2f%%)", rpt.SentSpans, rpt.AcceptedSpans, rpt.AcceptedRatio*100, rpt.DroppedSpans, rpt.DroppedRatio*100)
+}
+
+// metricsScraper scrapes collector metrics before task, every interval if set and after task
+type metricsScraper struct {
+	tkconf   *TaskConfig
+	client   *http.Client
+	before   *MetricsSample
+	samples  []*MetricsSample
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func startMetricsScraper(tkconf *TaskConfig) (*metricsScraper, error) {
+	s := &metricsScraper{
+		tkconf: tkconf,
+		client: &http.Client{Timeout: 10 * time.Second},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if tkconf.CollectorMetricsInterval != "" {
+		interval, err := time.ParseDuration(tkconf.CollectorMetricsInterval)
+		if err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("invalid collector metrics interval: %s", tkconf.CollectorMetricsInterval)
+		}
+		s.interval = interval
+	}
+
+	var err error
+	if s.before, err = s.scrape(); err != nil {
+		return nil, err
+	}
+	go s.run()
+
+	return s, nil
+}
+
+func (s *metricsScraper) run() {
+	defer close(s.done)
+
+	if s.interval == 0 {
+		<-s.stop
+
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if sample, err := s.scrape(); err != nil {
+				log.Printf("scrape collector metrics: %s", err.Error())
+			} else {
+				s.samples = append(s.samples, sample)
+			}
+		}
+	}
+}
+
+// Stop scrapes the last time and correlates metrics with spans sent during task
+func (s *metricsScraper) Stop(sent *agent.Stats) (*CollectorMetricsReport, error) {
+	close(s.stop)
+	<-s.done
+
+	after, err := s.scrape()
+	if err != nil {
+		return nil, err
+	}
+
+	rpt := &CollectorMetricsReport{
+		URL:     s.tkconf.CollectorMetricsURL,
+		Before:  s.before,
+		After:   after,
+		Deltas:  make(map[string]float64),
+		Samples: s.samples,
+	}
+	for name, v := range after.Values {
+		rpt.Deltas[name] = v - s.before.Values[name]
+	}
+	if sent != nil {
+		// the collector accepts warm-up spans as well
+		rpt.SentSpans = sent.Spans + sent.WarmupSpans
+	}
+	if name := s.tkconf.CollectorAcceptedMetric; name != "" {
+		rpt.AcceptedSpans = rpt.Deltas[name]
+	}
+	if name := s.tkconf.CollectorDroppedMetric; name != "" {
+		rpt.DroppedSpans = rpt.Deltas[name]
+	}
+	if rpt.SentSpans > 0 {
+		rpt.AcceptedRatio = rpt.AcceptedSpans / float64(rpt.SentSpans)
+		rpt.DroppedRatio = rpt.DroppedSpans / float64(rpt.SentSpans)
+	}
+
+	return rpt, nil
+}
+
+func (s *metricsScraper) scrape() (*MetricsSample, error) {
+	resp, err := s.client.Get(s.tkconf.CollectorMetricsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape %s: %s", s.tkconf.CollectorMetricsURL, resp.Status)
+	}
+
+	return parseMetrics(resp.Body, s.tkconf.metricSelectors())
+}
+
+// metricSelectors returns all metrics to scrape, including the ones used for correlation
+func (tkconf *TaskConfig) metricSelectors() []string {
+	selectors := append([]string(nil), tkconf.CollectorMetrics...)
+	for _, name := range []string{tkconf.CollectorAcceptedMetric, tkconf.CollectorDroppedMetric} {
+		found := false
+		for _, sel := range selectors {
+			found = found || sel == name
+		}
+		if name != "" && !found {
+			selectors = append(selectors, name)
+		}
+	}
+
+	return selectors
+}
+
+type metricSelector struct {
+	name   string
+	labels map[string]string
+}
+
+// parseMetricSelector accepts a bare metric name or name{label="value",...},
+// a selector matches every series of name carrying all the labels given
+func parseMetricSelector(sel string) (*metricSelector, error) {
+	name, labels, err := splitSeries(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metricSelector{name: name, labels: labels}, nil
+}
+
+func (ms *metricSelector) match(name string, labels map[string]string) bool {
+	if ms.name != name {
+		return false
+	}
+	for k, v := range ms.labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseMetrics sums the values of series matched by each selector in Prometheus text format
+func parseMetrics(r io.Reader, selectors []string) (*MetricsSample, error) {
+	var (
+		sample = &MetricsSample{Time: time.Now(), Values: make(map[string]float64)}
+		sels   = make([]*metricSelector, len(selectors))
+		err    error
+	)
+	for i, sel := range selectors {
+		if sels[i], err = parseMetricSelector(sel); err != nil {
+			return nil, err
+		}
+		sample.Values[sel] = 0
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		// value follows series and may be followed by timestamp
+		var series, rest string
+		if i := strings.LastIndexByte(line, '}'); i >= 0 {
+			series, rest = line[:i+1], line[i+1:]
+		} else if i = strings.IndexAny(line, " \t"); i >= 0 {
+			series, rest = line[:i], line[i:]
+		} else {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil || math.IsNaN(value) {
+			continue
+		}
+		name, labels, err := splitSeries(series)
+		if err != nil {
+			continue
+		}
+		for i, sel := range sels {
+			if sel.match(name, labels) {
+				sample.Values[selectors[i]] += value
+			}
+		}
+	}
+
+	return sample, scanner.Err()
+}
+
+func splitSeries(series string) (string, map[string]string, error) {
+	i := strings.IndexByte(series, '{')
+	if i < 0 {
+		return strings.TrimSpace(series), nil, nil
+	}
+	if !strings.HasSuffix(series, "}") {
+		return "", nil, fmt.Errorf("malformed series: %s", series)
+	}
+
+	var (
+		name   = strings.TrimSpace(series[:i])
+		body   = series[i+1 : len(series)-1]
+		labels = make(map[string]string)
+	)
+	for len(body) > 0 {
+		eq := strings.IndexByte(body, '=')
+		if eq < 0 || eq+1 >= len(body) || body[eq+1] != '"' {
+			return "", nil, fmt.Errorf("malformed series: %s", series)
+		}
+		key := strings.TrimSpace(body[:eq])
+		body = body[eq+2:]
+
+		var (
+			value   strings.Builder
+			escaped bool
+			end     = -1
+		)
+		for j := 0; j < len(body); j++ {
+			c := body[j]
+			if escaped {
+				switch c {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(c)
+				}
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				end = j
+				break
+			} else {
+				value.WriteByte(c)
+			}
+		}
+		if end < 0 {
+			return "", nil, fmt.Errorf("malformed series: %s", series)
+		}
+		labels[key] = value.String()
+		body = strings.TrimLeft(body[end+1:], " ,")
+	}
+
+	return name, labels, nil
+}
@@ -0,0 +1,97 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+)
+
+func TestMetricsScraper(t *testing.T) {
+	var scrapes int64
+	collector := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt64(&scrapes, 1) - 1
+		fmt.Fprintf(resp, `# HELP datakit_input_feed_total spans fed
+# TYPE datakit_input_feed_total counter
+datakit_input_feed_total{input="ddtrace",category="tracing"} %d
+datakit_input_feed_total{input="jaeger",category="tracing"} 7
+datakit_input_dropped_total{input="ddtrace"} %d 1690000000000
+datakit_queue_length 3
+`, 100+n*90, n*10)
+	}))
+	defer collector.Close()
+
+	tkconf := NewTaskConfig(TracerWithCollectorMetrics(collector.URL, []string{"datakit_queue_length", "datakit_input_feed_total"}, `datakit_input_feed_total{input="ddtrace"}`, "datakit_input_dropped_total"))
+	scraper, err := startMetricsScraper(tkconf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rpt, err := scraper.Stop(&agent.Stats{Spans: 100})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if rpt.Before.Values["datakit_input_feed_total"] != 107 || rpt.After.Values["datakit_input_feed_total"] != 197 {
+		t.Fatalf("series of bare name not summed: %v %v", rpt.Before.Values, rpt.After.Values)
+	}
+	if rpt.AcceptedSpans != 90 || rpt.DroppedSpans != 10 || rpt.AcceptedRatio != 0.9 || rpt.DroppedRatio != 0.1 {
+		t.Fatalf("unexpected correlation: %+v", rpt)
+	}
+	if rpt.Deltas["datakit_queue_length"] != 0 {
+		t.Fatalf("unexpected delta: %v", rpt.Deltas)
+	}
+}
+
+func TestRunFinalScrapeFailed(t *testing.T) {
+	var scrapes int64
+	metrics := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		// the collector stops exposing metrics after the first scrape
+		if atomic.AddInt64(&scrapes, 1) > 1 {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+		fmt.Fprintln(resp, "datakit_input_feed_total 1")
+	}))
+	defer metrics.Close()
+	collector := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	task := NewTaskConfig(
+		TracerWithName("scrape-failed"),
+		TracerWithArchive(newTestArchive(t)),
+		TracerWithAmplifier(1, 2),
+		withTestCollector(collector),
+		TracerWithCollectorMetrics(metrics.URL, []string{"datakit_input_feed_total"}, "", ""),
+	)
+	res, err := NewRunner().Run(context.TODO(), task)
+	if err != nil || res.Error != "" || res.Sent.Requests != 2 {
+		t.Fatalf("task failed by final scrape: %v %+v", err, res)
+	}
+	if res.Collector != nil || res.Warning == "" {
+		t.Fatalf("expect warning of final scrape: %+v", res)
+	}
+}
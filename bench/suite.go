@@ -0,0 +1,275 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SuiteConfig groups tasks by names, matrix names and tags
+type SuiteConfig struct {
+	Name string `json:"name"`
+	// names of tasks or matrices, a matrix stands for all tasks it expands into
+	Tasks []string `json:"tasks,omitempty"`
+	// tasks tagged with any of tags
+	Tags []string `json:"tags,omitempty"`
+}
+
+// MatrixExclude drops combinations matching all of its fields set
+type MatrixExclude struct {
+	Tracer        string `json:"tracer,omitempty"`
+	CollectorPath string `json:"collector_path,omitempty"`
+	Threads       int    `json:"threads,omitempty"`
+	BatchSize     int    `json:"batch_size,omitempty"`
+}
+
+func (ex *MatrixExclude) match(task *TaskConfig) bool {
+	return (ex.Tracer == "" || ex.Tracer == task.Tracer) &&
+		(ex.CollectorPath == "" || ex.CollectorPath == task.CollectorPath) &&
+		(ex.Threads == 0 || ex.Threads == task.SendThreads) &&
+		(ex.BatchSize == 0 || ex.BatchSize == task.BatchSize)
+}
+
+// MatrixConfig expands Task into one task for each combination of tracers, collector
+// paths, thread counts and batch sizes, a dimension left empty keeps the value of Task.
+type MatrixConfig struct {
+	Name           string           `json:"name"`
+	Tags           []string         `json:"tags,omitempty"`
+	Task           *TaskConfig      `json:"task"`
+	Tracers        []string         `json:"tracers,omitempty"`
+	CollectorPaths []string         `json:"collector_paths,omitempty"`
+	Threads        []int            `json:"threads,omitempty"`
+	BatchSizes     []int            `json:"batch_sizes,omitempty"`
+	Exclude        []*MatrixExclude `json:"exclude,omitempty"`
+}
+
+// Expand returns tasks of matrix named as <matrix>-<tracer>-<path>-t<threads>-b<batch size>,
+// dimensions left empty are not part of names
+func (mat *MatrixConfig) Expand() []*TaskConfig {
+	base := &TaskConfig{}
+	if mat.Task != nil {
+		base = mat.Task
+	}
+	tasks := []*TaskConfig{base.clone(mat.Name)}
+	tasks[0].Tags = appendTags(tasks[0].Tags, mat.Tags...)
+
+	tasks = expandDim(tasks, len(mat.Tracers), func(task *TaskConfig, i int) string {
+		task.Tracer = mat.Tracers[i]
+
+		return task.Tracer
+	})
+	tasks = expandDim(tasks, len(mat.CollectorPaths), func(task *TaskConfig, i int) string {
+		task.CollectorPath = mat.CollectorPaths[i]
+
+		return strings.ReplaceAll(strings.Trim(task.CollectorPath, "/"), "/", "-")
+	})
+	tasks = expandDim(tasks, len(mat.Threads), func(task *TaskConfig, i int) string {
+		task.SendThreads = mat.Threads[i]
+
+		return fmt.Sprintf("t%d", task.SendThreads)
+	})
+	tasks = expandDim(tasks, len(mat.BatchSizes), func(task *TaskConfig, i int) string {
+		task.BatchSize = mat.BatchSizes[i]
+		if task.BatchSize != 0 && task.BatchBy == "" {
+			task.BatchBy = "traces"
+		}
+
+		return fmt.Sprintf("b%d", task.BatchSize)
+	})
+
+	var kept []*TaskConfig
+	for _, task := range tasks {
+		excluded := false
+		for _, ex := range mat.Exclude {
+			if ex.match(task) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, task)
+		}
+	}
+
+	return kept
+}
+
+// expandDim multiplies tasks by n values set by set, which returns the name suffix of value
+func expandDim(tasks []*TaskConfig, n int, set func(task *TaskConfig, i int) string) []*TaskConfig {
+	if n == 0 {
+		return tasks
+	}
+
+	var expanded []*TaskConfig
+	for _, task := range tasks {
+		for i := 0; i < n; i++ {
+			cp := task.clone(task.Name)
+			cp.Name += "-" + set(cp, i)
+			expanded = append(expanded, cp)
+		}
+	}
+
+	return expanded
+}
+
+// clone copies task with name, slices owned by task are copied while the nested
+// configurations are shared
+func (tkconf *TaskConfig) clone(name string) *TaskConfig {
+	cp := *tkconf
+	cp.Name = name
+	cp.Tags = append([]string(nil), tkconf.Tags...)
+
+	return &cp
+}
+
+func (tkconf *TaskConfig) hasTag(tags ...string) bool {
+	for _, tag := range tags {
+		if contains(tkconf.Tags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func appendTags(tags []string, more ...string) []string {
+	for _, tag := range more {
+		if !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// AllTasks returns tasks followed by tasks expanded from matrices
+func (bconf *BenchConfig) AllTasks() []*TaskConfig {
+	tasks := append([]*TaskConfig(nil), bconf.Tasks...)
+	for _, mat := range bconf.Matrices {
+		tasks = append(tasks, mat.Expand()...)
+	}
+
+	return tasks
+}
+
+// SelectTasks returns tasks by names of tasks or matrices in order, followed by tasks of
+// suites and tags in the order of AllTasks not selected yet. A name, suite or tag selecting
+// nothing is an error.
+func (bconf *BenchConfig) SelectTasks(names, suites, tags []string) ([]*TaskConfig, error) {
+	all := bconf.AllTasks()
+	byName := func(name string) ([]*TaskConfig, error) {
+		for _, mat := range bconf.Matrices {
+			if mat.Name == name {
+				return mat.Expand(), nil
+			}
+		}
+		for _, task := range all {
+			if task.Name == name {
+				return []*TaskConfig{task}, nil
+			}
+		}
+
+		return nil, fmt.Errorf("task: %s not found", name)
+	}
+
+	var selected []*TaskConfig
+	for _, name := range names {
+		tasks, err := byName(name)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, tasks...)
+	}
+
+	var (
+		picked = make(map[string]bool)
+		pick   = func(tasks ...*TaskConfig) {
+			for _, task := range tasks {
+				picked[task.Name] = true
+			}
+		}
+		pickTag = func(tag string) bool {
+			found := false
+			for _, task := range all {
+				if task.hasTag(tag) {
+					pick(task)
+					found = true
+				}
+			}
+
+			return found
+		}
+	)
+	for _, tag := range tags {
+		if !pickTag(tag) {
+			return nil, fmt.Errorf("no task tagged %s", tag)
+		}
+	}
+	for _, name := range suites {
+		suite := bconf.findSuite(name)
+		if suite == nil {
+			return nil, fmt.Errorf("suite: %s not found", name)
+		}
+		for _, tname := range suite.Tasks {
+			tasks, err := byName(tname)
+			if err != nil {
+				return nil, fmt.Errorf("suite: %s %s", name, err.Error())
+			}
+			pick(tasks...)
+		}
+		for _, tag := range suite.Tags {
+			if !pickTag(tag) {
+				return nil, fmt.Errorf("suite: %s no task tagged %s", name, tag)
+			}
+		}
+	}
+	for _, task := range selected {
+		delete(picked, task.Name)
+	}
+	for _, task := range all {
+		if picked[task.Name] {
+			selected = append(selected, task)
+		}
+	}
+
+	return selected, nil
+}
+
+func (bconf *BenchConfig) findSuite(name string) *SuiteConfig {
+	for _, suite := range bconf.Suites {
+		if suite.Name == name {
+			return suite
+		}
+	}
+
+	return nil
+}
+
+// checkNames reports tasks, including generated ones, sharing names
+func (bconf *BenchConfig) checkNames() error {
+	seen := make(map[string]bool)
+	for _, task := range bconf.AllTasks() {
+		if seen[task.Name] {
+			return fmt.Errorf("task: %s defined more than once", task.Name)
+		}
+		seen[task.Name] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"reflect"
+	"testing"
+)
+
+func taskNames(tasks []*TaskConfig) []string {
+	var names []string
+	for _, task := range tasks {
+		names = append(names, task.Name)
+	}
+
+	return names
+}
+
+func TestMatrixExpand(t *testing.T) {
+	mat := &MatrixConfig{
+		Name:       "nightly",
+		Tags:       []string{"nightly"},
+		Task:       NewTaskConfig(TracerWithTags("load"), TracerWithAmplifier(1, 100)),
+		Tracers:    []string{DDTrace, Jaeger},
+		Threads:    []int{1, 10},
+		BatchSizes: []int{0, 100},
+		Exclude:    []*MatrixExclude{{Tracer: Jaeger, BatchSize: 100}},
+	}
+	tasks := mat.Expand()
+	want := []string{
+		"nightly-ddtrace-t1-b0", "nightly-ddtrace-t1-b100", "nightly-ddtrace-t10-b0", "nightly-ddtrace-t10-b100",
+		"nightly-jaeger-t1-b0", "nightly-jaeger-t10-b0",
+	}
+	if got := taskNames(tasks); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expect tasks %v got %v", want, got)
+	}
+	last := tasks[3]
+	if last.Tracer != DDTrace || last.SendThreads != 10 || last.BatchSize != 100 || last.BatchBy != "traces" || last.SendTimesPerThread != 100 {
+		t.Fatalf("unexpected task: %+v", last)
+	}
+	if !reflect.DeepEqual(last.Tags, []string{"load", "nightly"}) || len(mat.Task.Tags) != 1 {
+		t.Fatalf("unexpected tags: %v template: %v", last.Tags, mat.Task.Tags)
+	}
+}
+
+func TestSelectTasks(t *testing.T) {
+	bconf := &BenchConfig{
+		Tasks: []*TaskConfig{
+			NewTaskConfig(TracerWithName("dd"), TracerWithTags("smoke")),
+			NewTaskConfig(TracerWithName("jg"), TracerWithTags("smoke", "jaeger")),
+			NewTaskConfig(TracerWithName("replay")),
+		},
+		Matrices: []*MatrixConfig{{Name: "mat", Tracers: []string{DDTrace, Jaeger}}},
+		Suites: []*SuiteConfig{
+			{Name: "nightly", Tasks: []string{"mat", "replay"}},
+			{Name: "smoke", Tags: []string{"smoke"}},
+		},
+	}
+	cases := []struct {
+		names, suites, tags []string
+		want                []string
+	}{
+		{[]string{"replay", "dd", "replay"}, nil, nil, []string{"replay", "dd", "replay"}},
+		{nil, []string{"nightly"}, nil, []string{"replay", "mat-ddtrace", "mat-jaeger"}},
+		{nil, []string{"smoke"}, []string{"jaeger"}, []string{"dd", "jg"}},
+		{[]string{"jg"}, nil, []string{"smoke"}, []string{"jg", "dd"}},
+		{[]string{"mat"}, nil, nil, []string{"mat-ddtrace", "mat-jaeger"}},
+	}
+	for _, c := range cases {
+		tasks, err := bconf.SelectTasks(c.names, c.suites, c.tags)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if got := taskNames(tasks); !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("%v %v %v: expect %v got %v", c.names, c.suites, c.tags, c.want, got)
+		}
+	}
+
+	for _, sel := range [][3][]string{{{"missing"}, nil, nil}, {nil, {"missing"}, nil}, {nil, nil, {"missing"}}} {
+		if _, err := bconf.SelectTasks(sel[0], sel[1], sel[2]); err == nil {
+			t.Fatalf("%v: expect error", sel)
+		}
+	}
+
+	bconf.Tasks = append(bconf.Tasks, NewTaskConfig(TracerWithName("mat-jaeger")))
+	if err := bconf.checkNames(); err == nil {
+		t.Fatal("expect error of task colliding with matrix")
+	}
+}
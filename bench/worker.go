@@ -0,0 +1,310 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+)
+
+var defWorkerStartDelay = 5 * time.Second
+
+// workerJob is the share of a task sent by coordinator to one worker, threads are held
+// for StartDelay since the job is received so that all workers start sending together.
+// The delay is relative so that worker clocks need not be synchronized with coordinator.
+type workerJob struct {
+	Task       *TaskConfig   `json:"task"`
+	StartDelay time.Duration `json:"start_delay"`
+}
+
+type WorkerServerOption func(ws *WorkerServer)
+
+// WorkerWithToken requires coordinator to send token as bearer token, see TracerWithWorkerToken.
+func WorkerWithToken(token string) WorkerServerOption {
+	return func(ws *WorkerServer) {
+		ws.token = token
+	}
+}
+
+// WorkerServer runs jobs posted by coordinator one at a time and responds with the task
+// result once the job is done, DELETE /jobs cancels the running job which still responds
+// with its partial result. Coordinator must send token as bearer token if not empty.
+type WorkerServer struct {
+	sync.Mutex
+	runner  *Runner
+	token   string
+	running sync.Mutex
+	cancel  context.CancelFunc
+}
+
+func NewWorkerServer(runner *Runner, opts ...WorkerServerOption) *WorkerServer {
+	ws := &WorkerServer{runner: runner}
+	for _, opt := range opts {
+		opt(ws)
+	}
+
+	return ws
+}
+
+func (ws *WorkerServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/jobs" {
+		resp.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+	if ws.token != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+ws.token)) != 1 {
+		resp.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+	switch req.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		ws.Lock()
+		if ws.cancel != nil {
+			ws.cancel()
+		}
+		ws.Unlock()
+		resp.WriteHeader(http.StatusNoContent)
+
+		return
+	default:
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	job := &workerJob{}
+	if err := json.NewDecoder(req.Body).Decode(job); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if job.Task == nil {
+		http.Error(resp, "task required", http.StatusBadRequest)
+
+		return
+	}
+	startAt := time.Now().Add(job.StartDelay)
+	// never distribute again from a worker
+	job.Task.Workers = nil
+
+	ws.running.Lock()
+	defer ws.running.Unlock()
+
+	// canceled as well when coordinator goes away
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	ws.Lock()
+	ws.cancel = cancel
+	ws.Unlock()
+	defer func() {
+		ws.Lock()
+		ws.cancel = nil
+		ws.Unlock()
+	}()
+
+	log.Printf("job of task: %s with %d threads starts in %s", job.Task.Name, job.Task.SendThreads, job.StartDelay)
+	res, _ := ws.runner.Run(ctx, job.Task, agent.WithStartAt(startAt))
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(res); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// splitThreads shares threads among n workers as evenly as possible
+func splitThreads(threads, n int) []int {
+	shares := make([]int, n)
+	for i := range shares {
+		shares[i] = threads / n
+		if i < threads%n {
+			shares[i]++
+		}
+	}
+
+	return shares
+}
+
+func workerJobsURL(worker string) string {
+	if !strings.Contains(worker, "://") {
+		worker = "http://" + worker
+	}
+
+	return strings.TrimSuffix(worker, "/") + "/jobs"
+}
+
+// newWorkerRequest builds request of worker jobs carrying token as bearer token if not empty
+func newWorkerRequest(method, worker, token string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, workerJobsURL(worker), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+func cancelWorkerJob(worker, token string) {
+	req, err := newWorkerRequest(http.MethodDelete, worker, token, nil)
+	if err != nil {
+		log.Println(err.Error())
+
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Println(err.Error())
+
+		return
+	}
+	resp.Body.Close()
+}
+
+func postWorkerJob(worker, token string, job *workerJob) (*Result, error) {
+	bts, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	req, err := newWorkerRequest(http.MethodPost, worker, token, bts)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("worker responds with status: %s", resp.Status)
+	}
+	res := &Result{}
+	if err = json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// distributeTask shares threads of task among its workers and starts them at the same time,
+// stats reported by workers are merged into stats. Every worker captures traces with its own
+// tracer, so route and archive paths are resolved on workers. Each job carries the delay left
+// until the start time when it is posted. Process monitor and collector metrics stay on
+// coordinator. Workers are asked to cancel their jobs once ctx is done so that their partial
+// results are still reported.
+func distributeTask(ctx context.Context, task *TaskConfig, stats *agent.Stats) ([]*Result, error) {
+	delay := defWorkerStartDelay
+	if task.WorkerStartDelay != "" {
+		var err error
+		if delay, err = time.ParseDuration(task.WorkerStartDelay); err != nil {
+			return nil, err
+		}
+	}
+	token, err := expandEnv(task.WorkerToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		shares  = splitThreads(task.SendThreads, len(task.Workers))
+		startAt = time.Now().Add(delay)
+		results = make([]*Result, len(task.Workers))
+		wg      sync.WaitGroup
+	)
+	for i, worker := range task.Workers {
+		if shares[i] == 0 {
+			continue
+		}
+
+		share := *task
+		share.SendThreads = shares[i]
+		share.Workers = nil
+		share.WorkerStartDelay = ""
+		share.WorkerToken = ""
+		share.Monitor = nil
+		share.CollectorMetricsURL = ""
+		share.CollectorMetrics = nil
+
+		wg.Add(1)
+		go func(i int, worker string) {
+			defer wg.Done()
+
+			log.Printf("task: %s sends %d threads to worker: %s", task.Name, share.SendThreads, worker)
+			res, err := postWorkerJob(worker, token, &workerJob{Task: &share, StartDelay: time.Until(startAt)})
+			if err != nil {
+				res = &Result{Name: task.Name, Tracer: task.Tracer, Error: err.Error()}
+			}
+			res.Worker = worker
+			results[i] = res
+		}(i, worker)
+	}
+	var (
+		done    = make(chan struct{})
+		stopped = make(chan struct{})
+	)
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			for _, worker := range task.Workers {
+				cancelWorkerJob(worker, token)
+			}
+		case <-done:
+		}
+	}()
+	wg.Wait()
+	close(done)
+	<-stopped
+
+	var (
+		reported []*Result
+		errs     []string
+	)
+	for _, res := range results {
+		if res == nil {
+			continue
+		}
+		reported = append(reported, res)
+		if res.Sent != nil {
+			stats.Merge(res.Sent)
+		}
+		if res.Error != "" {
+			errs = append(errs, fmt.Sprintf("worker %s: %s", res.Worker, res.Error))
+		}
+	}
+	if len(errs) != 0 {
+		return reported, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return reported, nil
+}
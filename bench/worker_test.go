@@ -0,0 +1,136 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+)
+
+func TestSplitThreads(t *testing.T) {
+	shares := splitThreads(5, 3)
+	if shares[0] != 2 || shares[1] != 2 || shares[2] != 1 {
+		t.Fatalf("unexpected shares: %v", shares)
+	}
+}
+
+func TestDistributeTask(t *testing.T) {
+	var received int32
+	collector := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&received, 1)
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	path := newTestArchive(t)
+
+	var workers []string
+	for i := 0; i < 2; i++ {
+		worker := httptest.NewServer(NewWorkerServer(NewRunner()))
+		defer worker.Close()
+		workers = append(workers, worker.URL)
+	}
+
+	task := NewTaskConfig(
+		TracerWithName("distributed"),
+		TracerWithArchive(path),
+		TracerWithAmplifier(3, 2),
+		withTestCollector(collector),
+		TracerWithWorkers(workers, "200ms"),
+	)
+	res, err := NewRunner().Run(context.TODO(), task)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if c := atomic.LoadInt32(&received); c != 6 {
+		t.Fatalf("expect 6 requests got %d", c)
+	}
+	if len(res.Workers) != 2 || res.Sent.Requests != 6 || res.Sent.Planned != 6 || len(res.Sent.Threads) != 3 || res.Sent.Latency.Count != 6 {
+		t.Fatalf("unexpected merged result: %+v", res.Sent)
+	}
+}
+
+func TestDistributeTaskToken(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	worker := httptest.NewServer(NewWorkerServer(NewRunner(), WorkerWithToken("secret")))
+	defer worker.Close()
+
+	path := newTestArchive(t)
+	t.Setenv("DKB_TEST_WORKER_TOKEN", "secret")
+	for token, ok := range map[string]bool{"${DKB_TEST_WORKER_TOKEN}": true, "": false, "wrong": false, "${DKB_TEST_UNSET_TOKEN}": false} {
+		task := NewTaskConfig(
+			TracerWithName("distributed"),
+			TracerWithArchive(path),
+			TracerWithAmplifier(2, 1),
+			withTestCollector(collector),
+			TracerWithWorkers([]string{worker.URL}, "100ms"),
+			TracerWithWorkerToken(token),
+		)
+		res, err := NewRunner().Run(context.TODO(), task)
+		if (err == nil) != ok {
+			t.Fatalf("token %q: expect ok %v got error %v", token, ok, err)
+		}
+		if ok && res.Sent.Requests != 2 {
+			t.Fatalf("token %q: unexpected result: %+v", token, res.Sent)
+		}
+	}
+}
+
+func TestWorkerJobStartDelay(t *testing.T) {
+	var (
+		job    workerJob
+		header http.Header
+	)
+	worker := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		header = req.Header.Clone()
+		if err := json.NewDecoder(req.Body).Decode(&job); err != nil {
+			t.Error(err.Error())
+		}
+		json.NewEncoder(resp).Encode(&Result{Name: job.Task.Name, Sent: agent.NewStats()})
+	}))
+	defer worker.Close()
+
+	task := NewTaskConfig(
+		TracerWithName("distributed"),
+		TracerWithAmplifier(1, 1),
+		TracerWithWorkers([]string{strings.TrimPrefix(worker.URL, "http://")}, "1s"),
+		TracerWithWorkerToken("secret"),
+	)
+	if _, err := distributeTask(context.TODO(), task, agent.NewStats()); err != nil {
+		t.Fatal(err.Error())
+	}
+	// delay left is relative to receiving, never a time of coordinator clock
+	if job.StartDelay <= 0 || job.StartDelay > time.Second {
+		t.Fatalf("unexpected start delay: %s", job.StartDelay)
+	}
+	if job.Task.WorkerToken != "" || len(job.Task.Workers) != 0 || header.Get("Authorization") != "Bearer secret" {
+		t.Fatalf("unexpected job: %+v %v", job.Task, header)
+	}
+}
@@ -19,119 +19,485 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
+	"github.com/CodapeWild/dktrace-data-benchmark/bench"
 	"github.com/spf13/cobra"
 )
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:     "dktrace-data-benchmark",
-	Aliases: []string{"dkb", "dkbench"},
-	Short:   "benchmark widget written for Datakit testing of trace modules",
+	Use:          "dktrace-data-benchmark",
+	Aliases:      []string{"dkb", "dkbench"},
+	Short:        "benchmark widget written for Datakit testing of trace modules",
+	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(configPath); err != nil {
+			return err
+		}
+		level := logLevel
+		if !cmd.Flags().Changed("log-level") && gBenchConf.DisableLog {
+			level = logOff
+		}
+
+		return setLogLevel(level)
+	},
 }
 
-// configCmd represents the config command
-var configCmd = &cobra.Command{
-	Use:   "config",
-	Short: "benchmark configuration file path in JSON format",
-	Run: func(cmd *cobra.Command, args []string) {
+// taskCmd represents the task command
+var taskCmd = &cobra.Command{
+	Use:   "task",
+	Short: "manage tasks saved in configuration file",
+	Long:  "Manage tasks saved in configuration file.\n\n" + taskSaveNote,
+}
+
+// taskSaveNote is the help of commands saving tasks, see bench.DumpBenchConfigFile
+const taskSaveNote = `Configurations using include or ${ENV} references are read only, add, update and remove
+refuse to save them, edit their files instead.`
+
+// taskAddCmd represents the task add command
+var taskAddCmd = &cobra.Command{
+	Use:   "add [name]",
+	Short: "add task built from defaults, --json and flags in order, name is taken from --json if not offered",
+	Long:  "Add task built from defaults, --json and flags in order, name is taken from --json if not offered.\n\n" + taskSaveNote,
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		task, err := copyTask(defTask)
+		if err != nil {
+			return err
+		}
+		if taskJSON != "" {
+			if err := json.Unmarshal([]byte(taskJSON), task); err != nil {
+				return err
+			}
+		}
 		if len(args) != 0 {
-			defBenchConf = args[0]
+			task.Name = args[0]
+		}
+		for _, found := range gBenchConf.AllTasks() {
+			if found.Name == task.Name {
+				return fmt.Errorf("task: %s already exists", task.Name)
+			}
 		}
+		if err := taskFlags.apply(cmd, task); err != nil {
+			return err
+		}
+		if err := bench.Validate(task); err != nil {
+			return err
+		}
+		gBenchConf.Tasks = append(gBenchConf.Tasks, task)
+
+		return saveConfig(configPath)
 	},
 }
 
-// disableLogCmd represents the disableLog command
-var disableLogCmd = &cobra.Command{
-	Use:   "disable-log",
-	Short: "disable log output",
-	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) != 0 {
-			if ok, err := strconv.ParseBool(args[0]); err == nil {
-				defDisableLog = ok
+// taskUpdateCmd represents the task update command
+var taskUpdateCmd = &cobra.Command{
+	Use:   "update name",
+	Short: "update task by --json and flags offered, fields not offered are kept",
+	Long:  "Update task by --json and flags offered, fields not offered are kept.\n\n" + taskSaveNote,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		i, found := findTask(args[0])
+		if found == nil {
+			return fmt.Errorf("task: %s not found", args[0])
+		}
+		// fields of JSON are merged into a deep copy, the saved task is left as it is on failure
+		task, err := copyTask(found)
+		if err != nil {
+			return err
+		}
+		if taskJSON != "" {
+			if err := json.Unmarshal([]byte(taskJSON), task); err != nil {
+				return err
 			}
 		}
+		// the task keeps its name, remove and add it to rename
+		task.Name = found.Name
+		if err := taskFlags.apply(cmd, task); err != nil {
+			return err
+		}
+		if err := bench.Validate(task); err != nil {
+			return err
+		}
+		gBenchConf.Tasks[i] = task
+
+		return saveConfig(configPath)
 	},
 }
 
-// tasksCmd represents the tracer command
-var tasksCmd = &cobra.Command{
-	Use:   "tasks",
-	Short: "tasks configuration command, JSON object string required, multiple arguments supported",
-	Run: func(cmd *cobra.Command, args []string) {
+// taskRemoveCmd represents the task remove command
+var taskRemoveCmd = &cobra.Command{
+	Use:   "remove name...",
+	Short: "remove tasks by name",
+	Long:  "Remove tasks by name.\n\n" + taskSaveNote,
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		for _, arg := range args {
-			task := &taskConfig{}
-			if err := json.Unmarshal([]byte(arg), task); err != nil {
-				log.Println(err.Error())
-			} else {
-				gTasks = append(gTasks, task)
+			i, found := findTask(arg)
+			if found == nil {
+				return fmt.Errorf("task: %s not found", arg)
 			}
+			gBenchConf.Tasks = append(gBenchConf.Tasks[:i], gBenchConf.Tasks[i+1:]...)
 		}
 
-		if len(gTasks) != 0 {
-			mergeTasks(&gBenchConf.Tasks, gTasks)
-		}
-		if err := dumpBenchConfigFile(defBenchConf, gBenchConf); err != nil {
-			log.Println(err.Error())
+		return saveConfig(configPath)
+	},
+}
+
+// taskListCmd represents the task list command
+var taskListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list saved tasks in one line each",
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, task := range gBenchConf.AllTasks() {
+			proto := task.CollectorProto
+			if proto == "" {
+				proto = "http"
+			}
+			fmt.Printf("%s\t%s\t%s\t%d x %d\t%s://%s:%d%s\t%s\n", task.Name, task.Tracer, task.Version, task.SendThreads, task.SendTimesPerThread,
+				proto, task.CollectorIP, task.CollectorPort, task.CollectorPath, strings.Join(task.Tags, ","))
 		}
 	},
 }
 
-// showCmd represents the show command
-var showCmd = &cobra.Command{
-	Use:   "show",
+// taskShowCmd represents the task show command
+var taskShowCmd = &cobra.Command{
+	Use:   "show [name...]",
 	Short: "show all the saved tasks configuration if no task name offered, otherwise show as arguments provided",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tasks, err := selectTasks(args, nil, nil)
+		for _, task := range tasks {
+			task.Print()
+		}
+
+		return err
+	},
+}
+
+// protocolsCmd represents the protocols command
+var protocolsCmd = &cobra.Command{
+	Use:   "protocols",
+	Short: "show registered protocols with supported versions and default collector paths",
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) == 0 {
-			for _, task := range gBenchConf.Tasks {
-				task.Print()
-			}
-		} else {
-			for _, arg := range args {
-				for _, task := range gBenchConf.Tasks {
-					if task.Name == arg {
-						task.Print()
-					}
-				}
+		for _, proto := range bench.Protocols() {
+			log.Printf("Protocol: %s Versions: %v Collector paths: %v Recordable: %v Unix agent: %v", proto.Name, proto.Versions, proto.CollectorPaths, proto.Recordable(), proto.UnixAgent)
+		}
+	},
+}
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate [name...]",
+	Short: "validate all the saved tasks against their protocols without running them if no task name, suite or tag offered, otherwise validate as selected",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tasks, err := selectTasks(args, validateSel.suites, validateSel.tags)
+		failed := 0
+		for _, task := range tasks {
+			if verr := bench.Validate(task); verr != nil {
+				log.Println(verr.Error())
+				failed++
+			} else {
+				log.Printf("task: %s ok", task.Name)
 			}
 		}
+		if err == nil && failed != 0 {
+			err = fmt.Errorf("%d of %d tasks invalid", failed, len(tasks))
+		}
+
+		return err
 	},
 }
 
 // runCmd represents the run command
 var runCmd = &cobra.Command{
-	Use: "run",
-	Short: `run task by name, task name required, multiple arguments supported but normally do not input more
-	than 10 tasks at once which will take too long to complete`,
-	Run: func(cmd *cobra.Command, args []string) {
-		go runTaskThread()
-
-		var c = 0
-		for _, arg := range args {
-			found := false
-			for _, task := range gBenchConf.Tasks {
-				if task.Name == arg {
-					gTaskChan <- task
-					c++
-					found = true
-				}
-			}
-			if !found {
-				log.Printf("task: %s not found", arg)
-			}
+	Use: "run [name...]",
+	Short: `run tasks selected by names of tasks or matrices, --suite and --tag, multiple selectors supported but
+	normally do not select more than 10 tasks at once which will take too long to complete`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && len(runSel.suites) == 0 && len(runSel.tags) == 0 {
+			return fmt.Errorf("task name, --suite or --tag required")
 		}
-		for range gFinish {
-			if c--; c == 0 {
-				log.Println("all tasks finished")
-				break
+		tasks, err := selectTasks(args, runSel.suites, runSel.tags)
+		if err != nil {
+			return err
+		}
+		for i, task := range tasks {
+			// overrides apply to this run only and are never saved
+			adhoc := *task
+			if err = runFlags.apply(cmd, &adhoc); err != nil {
+				return err
 			}
+			tasks[i] = &adhoc
+		}
+
+		ctx, cancel, err := newRunContext(gBenchConf.Timeout)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		if gBenchConf.MetricsAddress != "" {
+			startMetricsServer(gBenchConf.MetricsAddress)
+		}
+		parallel, budget := gBenchConf.Parallel, gBenchConf.CPUBudget
+		if cmd.Flags().Changed("parallel") {
+			parallel = runParallel
+		}
+		if cmd.Flags().Changed("cpu-budget") {
+			budget = runCPUBudget
+		}
+		cooldown := gBenchConf.Cooldown
+		if cmd.Flags().Changed("cooldown") {
+			cooldown = runCooldown
 		}
+		pause, err := parseCooldown(cooldown)
+		if err != nil {
+			return err
+		}
+		go runTaskThread(ctx, bench.NewCPUBudget(budget), pause)
+		var pv *progressView
+		if !noProgress {
+			pv = startProgressView()
+		}
+		results := runUnits(bench.PlanTasks(tasks, parallel), len(tasks))
+		if pv != nil {
+			pv.Stop()
+		}
+		log.Println("all tasks finished")
+		for _, res := range results {
+			res.Print()
+		}
+
+		return bench.DumpResults(resultOutput(), results)
 	},
 }
 
+// recordCmd represents the record command
+var recordCmd = &cobra.Command{
+	Use:   "record name archive",
+	Short: "record payloads captured from task tracer into archive for replay, task name and archive path required",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tasks, err := gBenchConf.SelectTasks(args[:1], nil, nil)
+		if err != nil {
+			return err
+		}
+		if len(tasks) != 1 {
+			return fmt.Errorf("record one task at a time, %s selects %d tasks", args[0], len(tasks))
+		}
+		task := tasks[0]
+		ctx, cancel, err := newRunContext(gBenchConf.Timeout)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		if err = gRunner.Record(ctx, task, args[1]); err != nil {
+			return err
+		}
+		log.Printf("task: %s recorded into %s", task.Name, args[1])
+
+		return nil
+	},
+}
+
+// proxyCmd represents the proxy command
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "forward trace requests unchanged to upstream collector and archive them for replay until interrupted",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runProxy(proxyConf)
+	},
+}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve REST API to manage tasks, submit runs, stream their progress, cancel them and fetch results",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServe(serveAddress)
+	},
+}
+
+// workerCmd represents the worker command
+var workerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "run share of tasks distributed by coordinator, a task with workers configured is run as coordinator",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// the token of environment is not shown as flag default by help
+		if workerToken == "" {
+			workerToken = defWorkerToken
+		}
+
+		return runWorker(workerAddress, workerToken)
+	},
+}
+
+// taskOverrides are task fields settable by flags, only flags changed on command line apply
+type taskOverrides struct {
+	tracer    string
+	version   string
+	route     string
+	archive   string
+	threads   int
+	repeat    int
+	warmupReq int
+	warmupDur string
+	collector string
+	agentSock bool
+}
+
+func (tov *taskOverrides) register(cmd *cobra.Command, full bool) {
+	if full {
+		cmd.Flags().StringVar(&tov.tracer, "tracer", defTask.Tracer, "tracer protocol, see protocols command")
+		cmd.Flags().StringVar(&tov.version, "version", "", "tracer version replayed from fixture, empty runs the tracer")
+		cmd.Flags().StringVar(&tov.route, "route", defTask.RouteConfig, "route file path")
+		cmd.Flags().StringVar(&tov.archive, "archive", "", "archive path replayed instead of running tracer, sets tracer to replay")
+	}
+	cmd.Flags().IntVar(&tov.threads, "threads", defTask.SendThreads, "amplifier threads")
+	cmd.Flags().IntVar(&tov.repeat, "repeat", defTask.SendTimesPerThread, "requests sent by each thread")
+	cmd.Flags().IntVar(&tov.warmupReq, "warmup-requests", 0, "warm-up requests sent by each thread before measured ones")
+	cmd.Flags().StringVar(&tov.warmupDur, "warmup-duration", "", "warm-up duration of each thread before measured requests, ends with warm-up requests if sooner")
+	cmd.Flags().StringVar(&tov.collector, "collector", "", "collector URL such as http://127.0.0.1:9529/v0.4/traces, path may be omitted for the protocol default, or unix:///path/to/socket")
+	cmd.Flags().BoolVar(&tov.agentSock, "agent-socket", false, "tracer sends to the capture agent over a Unix socket")
+}
+
+func (tov *taskOverrides) apply(cmd *cobra.Command, task *bench.TaskConfig) error {
+	var opts []bench.TracerConfigOption
+	flags := cmd.Flags()
+	if flags.Changed("tracer") {
+		opts = append(opts, bench.TracerWithTracer(tov.tracer))
+	}
+	if flags.Changed("version") {
+		opts = append(opts, bench.TracerWithVersion(tov.version))
+	}
+	if flags.Changed("route") {
+		opts = append(opts, bench.TracerWithRoute(tov.route))
+	}
+	if flags.Changed("archive") {
+		opts = append(opts, bench.TracerWithArchive(tov.archive))
+	}
+	if flags.Changed("threads") || flags.Changed("repeat") {
+		threads, repeat := task.SendThreads, task.SendTimesPerThread
+		if flags.Changed("threads") {
+			threads = tov.threads
+		}
+		if flags.Changed("repeat") {
+			repeat = tov.repeat
+		}
+		opts = append(opts, bench.TracerWithAmplifier(threads, repeat))
+	}
+	if flags.Changed("warmup-requests") || flags.Changed("warmup-duration") {
+		requests, duration := task.WarmupRequests, task.WarmupDuration
+		if flags.Changed("warmup-requests") {
+			requests = tov.warmupReq
+		}
+		if flags.Changed("warmup-duration") {
+			duration = tov.warmupDur
+		}
+		opts = append(opts, bench.TracerWithWarmup(requests, duration))
+	}
+	if flags.Changed("agent-socket") {
+		opts = append(opts, bench.TracerWithAgentSocket(tov.agentSock))
+	}
+	if flags.Changed("collector") {
+		opt, err := collectorOption(tov.collector)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, opt)
+	}
+	task.With(opts...)
+
+	return nil
+}
+
+// collectorOption parses collector URL, port defaults to the one of scheme
+func collectorOption(collector string) (bench.TracerConfigOption, error) {
+	u, err := url.Parse(collector)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == bench.CollectorUnix {
+		if u.Path == "" {
+			return nil, fmt.Errorf("socket path required by collector URL: %s", collector)
+		}
+
+		return bench.TracerWithCollectorSocket(u.Path, ""), nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported collector URL: %s", collector)
+	}
+	port := 80
+	if u.Scheme == "https" {
+		port = 443
+	}
+	host := u.Host
+	if h, p, err := net.SplitHostPort(u.Host); err == nil {
+		host = h
+		if port, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("invalid collector port: %s", p)
+		}
+	}
+
+	return bench.TracerWithCollector(u.Scheme, host, port, u.Path), nil
+}
+
+// taskSelectors are suites and tags selecting tasks besides names
+type taskSelectors struct {
+	suites []string
+	tags   []string
+}
+
+func (sel *taskSelectors) register(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&sel.suites, "suite", nil, "select tasks of suite, repeatable")
+	cmd.Flags().StringSliceVar(&sel.tags, "tag", nil, "select tasks tagged, repeatable")
+}
+
+// selectTasks returns tasks selected by names, suites and tags, all of them including the
+// ones expanded from matrices if nothing selected
+func selectTasks(names, suites, tags []string) ([]*bench.TaskConfig, error) {
+	if len(names) == 0 && len(suites) == 0 && len(tags) == 0 {
+		return gBenchConf.AllTasks(), nil
+	}
+
+	return gBenchConf.SelectTasks(names, suites, tags)
+}
+
+// resultOutput returns results path of --output, or the one configured
+func resultOutput() string {
+	if outputPath != "" {
+		return outputPath
+	}
+
+	return gBenchConf.Output
+}
+
+var (
+	configPath    string
+	logLevel      string
+	outputPath    string
+	taskJSON      string
+	taskFlags     = &taskOverrides{}
+	runFlags      = &taskOverrides{}
+	runSel        = &taskSelectors{}
+	runParallel   bool
+	runCPUBudget  int
+	runCooldown   string
+	validateSel   = &taskSelectors{}
+	proxyConf     = &proxyConfig{}
+	noProgress    bool
+	serveAddress  string
+	workerAddress string
+	workerToken   string
+)
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -142,23 +508,50 @@ func Execute() {
 }
 
 func init() {
-	// Here you will define your flags and configuration settings.
-	// Cobra supports persistent flags, which, if defined here,
-	// will be global for your application.
-
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.cobratest.yaml)")
-
-	// Cobra also supports local flags, which will only run
-	// when this action is called directly.
-	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-	// add config command
-	rootCmd.AddCommand(configCmd)
-	// add enable log command
-	rootCmd.AddCommand(disableLogCmd)
-	// add tasks command
-	rootCmd.AddCommand(tasksCmd)
-	// add show command
-	rootCmd.AddCommand(showCmd)
+	// environment variables are defaults of persistent flags
+	loadEnvVariables()
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", defBenchConf, "benchmark configuration file path in JSON, YAML or TOML format by extension")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", defLogLevel, "log level, debug, info or off, debug also enables tracer library logs")
+	rootCmd.PersistentFlags().StringVar(&outputPath, "output", "", "results output path overriding the configured one")
+	// add task command
+	for _, cmd := range []*cobra.Command{taskAddCmd, taskUpdateCmd} {
+		cmd.Flags().StringVar(&taskJSON, "json", "", "task configuration in JSON object string, flags override its fields")
+		taskFlags.register(cmd, true)
+	}
+	taskCmd.AddCommand(taskAddCmd, taskUpdateCmd, taskRemoveCmd, taskListCmd, taskShowCmd)
+	rootCmd.AddCommand(taskCmd)
+	// add protocols command
+	rootCmd.AddCommand(protocolsCmd)
+	// add validate command
+	validateSel.register(validateCmd)
+	rootCmd.AddCommand(validateCmd)
 	// add run command
+	runFlags.register(runCmd, false)
+	runSel.register(runCmd)
+	runCmd.Flags().BoolVar(&runParallel, "parallel", false, "run tasks sharing parallel_group together, overrides parallel of configuration")
+	runCmd.Flags().IntVar(&runCPUBudget, "cpu-budget", 0, "cores shared by tasks running together, each sender thread counts as one, all cores of local host if 0")
+	runCmd.Flags().StringVar(&runCooldown, "cooldown", "", "pause between tasks run one after another, overrides cooldown of configuration")
+	runCmd.Flags().BoolVar(&noProgress, "no-progress", false, "disable progress view, summary lines are logged instead of the live view when stdout is not a terminal")
 	rootCmd.AddCommand(runCmd)
+	// add record command
+	rootCmd.AddCommand(recordCmd)
+	// add proxy command
+	proxyCmd.Flags().StringVar(&proxyConf.Upstream, "upstream", "http://127.0.0.1:9529", "base URL of the real collector")
+	proxyCmd.Flags().StringVar(&proxyConf.Archive, "archive", "./archives/proxy", "archive path prefix, one archive per ddtrace and jaeger protocol is created as <prefix>-<protocol>.jsonl")
+	proxyCmd.Flags().StringVar(&proxyConf.DDTrace, "ddtrace", "127.0.0.1:8126", "ddtrace listening address, empty to disable")
+	proxyCmd.Flags().StringVar(&proxyConf.Jaeger, "jaeger", "127.0.0.1:14268", "jaeger listening address, empty to disable")
+	proxyCmd.Flags().StringVar(&proxyConf.OTLP, "otlp", "127.0.0.1:4318", "OTLP/HTTP listening address, empty to disable")
+	proxyCmd.Flags().StringVar(&proxyConf.TLS.CAFile, "ca-file", "", "CA file verifying https upstream certificate, system roots if empty")
+	proxyCmd.Flags().StringVar(&proxyConf.TLS.CertFile, "cert-file", "", "client certificate file for mTLS with upstream")
+	proxyCmd.Flags().StringVar(&proxyConf.TLS.KeyFile, "key-file", "", "client key file for mTLS with upstream")
+	proxyCmd.Flags().StringVar(&proxyConf.TLS.ServerName, "server-name", "", "server name verified instead of upstream host")
+	proxyCmd.Flags().BoolVar(&proxyConf.TLS.InsecureSkipVerify, "insecure-skip-verify", false, "skip verification of upstream certificate")
+	rootCmd.AddCommand(proxyCmd)
+	// add serve command
+	serveCmd.Flags().StringVar(&serveAddress, "address", "127.0.0.1:8090", "control API listening address")
+	rootCmd.AddCommand(serveCmd)
+	// add worker command
+	workerCmd.Flags().StringVar(&workerAddress, "address", "127.0.0.1:8091", "worker listening address, loopback by default, set a token before listening on other interfaces")
+	workerCmd.Flags().StringVar(&workerToken, "token", "", "bearer token required from coordinator as worker_token of tasks, DKTRACE_WORKER_TOKEN if empty")
+	rootCmd.AddCommand(workerCmd)
 }
@@ -0,0 +1,167 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/bench"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func TestCollectorOption(t *testing.T) {
+	cases := []struct {
+		url   string
+		proto string
+		ip    string
+		port  int
+		path  string
+		ok    bool
+	}{
+		{"http://127.0.0.1:9529/v0.4/traces", "http", "127.0.0.1", 9529, "/v0.4/traces", true},
+		{"https://datakit.example.com", "https", "datakit.example.com", 443, "", true},
+		{"http://[::1]:9529", "http", "::1", 9529, "", true},
+		{"udp://127.0.0.1:6831", "", "", 0, "", false},
+		{"http://127.0.0.1:port", "", "", 0, "", false},
+		{"unix:///var/run/datakit/apm.socket", "unix", "", 0, "", true},
+		{"unix://", "", "", 0, "", false},
+	}
+	for _, c := range cases {
+		opt, err := collectorOption(c.url)
+		if (err == nil) != c.ok {
+			t.Fatalf("%s: expect ok %v got error %v", c.url, c.ok, err)
+		}
+		if err != nil {
+			continue
+		}
+		task := bench.NewTaskConfig(opt)
+		if task.CollectorProto != c.proto || task.CollectorIP != c.ip || task.CollectorPort != c.port || task.CollectorPath != c.path {
+			t.Fatalf("%s: unexpected collector %s %s %d %s", c.url, task.CollectorProto, task.CollectorIP, task.CollectorPort, task.CollectorPath)
+		}
+	}
+}
+
+func TestRunUnits(t *testing.T) {
+	go runTaskThread(context.Background(), bench.NewCPUBudget(0), 0)
+
+	// more units than gTaskChan holds, results are received while units are sent, parallel
+	// groups of planned units yield more than one result each
+	var tasks []*bench.TaskConfig
+	for i := 0; i < 2*cap(gTaskChan); i++ {
+		tasks = append(tasks, bench.NewTaskConfig(
+			bench.TracerWithName(fmt.Sprintf("unknown-%d", i)),
+			bench.TracerWithTracer("unknown"),
+			bench.TracerWithParallelGroup(fmt.Sprintf("group-%d", i%(cap(gTaskChan)+5))),
+		))
+	}
+	for _, parallel := range []bool{false, true} {
+		if results := runUnits(bench.PlanTasks(tasks, parallel), len(tasks)); len(results) != len(tasks) {
+			t.Fatalf("parallel: %v expect %d results got %d", parallel, len(tasks), len(results))
+		}
+	}
+}
+
+// runTaskCmd runs task command against configuration file at path as if flags were parsed from
+// command line, flags are reset afterwards
+func runTaskCmd(t *testing.T, path string, cmd *cobra.Command, json string, flags map[string]string, args ...string) error {
+	t.Helper()
+
+	defer func() {
+		taskJSON = ""
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			f.Value.Set(f.DefValue)
+			f.Changed = false
+		})
+	}()
+	for k, v := range flags {
+		if err := cmd.Flags().Set(k, v); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	taskJSON = json
+	configPath = path
+	if err := loadConfig(path); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return cmd.RunE(cmd, args)
+}
+
+func TestTaskCmd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	saved := func() []*bench.TaskConfig {
+		bconf, err := bench.LoadBenchConfigFile(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		return bconf.Tasks
+	}
+
+	if err := runTaskCmd(t, path, taskAddCmd, `{"headers":{"X-A":"1"},"tags":["a"]}`, map[string]string{"threads": "5"}, "t1"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := runTaskCmd(t, path, taskAddCmd, `{"name":"t2"}`, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := runTaskCmd(t, path, taskAddCmd, "", nil, "t1"); err == nil {
+		t.Fatal("expect error adding existing task")
+	}
+	tasks := saved()
+	if len(tasks) != 2 || tasks[0].Name != "t1" || tasks[1].Name != "t2" {
+		t.Fatalf("unexpected tasks saved: %v", tasks)
+	}
+	if tasks[0].SendThreads != 5 || tasks[0].SendTimesPerThread != defTask.SendTimesPerThread || tasks[0].Headers["X-A"] != "1" {
+		t.Fatalf("unexpected task added: %+v", tasks[0])
+	}
+
+	// failed update leaves both the loaded and the saved task as they are
+	if err := runTaskCmd(t, path, taskUpdateCmd, `{"headers":{"X-B":"2"},"tags":["b"],"tracer":"unknown"}`, nil, "t1"); err == nil {
+		t.Fatal("expect error updating task with unknown tracer")
+	}
+	if _, found := findTask("t1"); len(found.Headers) != 1 || len(found.Tags) != 1 || found.Tracer != defTask.Tracer {
+		t.Fatalf("failed update changed loaded task: %+v", found)
+	}
+	if err := runTaskCmd(t, path, taskUpdateCmd, `{"name":"renamed","headers":{"X-B":"2"}}`, map[string]string{"repeat": "7"}, "t1"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := runTaskCmd(t, path, taskUpdateCmd, "", nil, "t3"); err == nil {
+		t.Fatal("expect error updating missing task")
+	}
+	tasks = saved()
+	if tasks[0].Name != "t1" || tasks[0].SendThreads != 5 || tasks[0].SendTimesPerThread != 7 || tasks[0].Headers["X-A"] != "1" || tasks[0].Headers["X-B"] != "2" {
+		t.Fatalf("unexpected task updated: %+v", tasks[0])
+	}
+
+	if err := runTaskCmd(t, path, taskRemoveCmd, "", nil, "t1", "t3"); err == nil {
+		t.Fatal("expect error removing missing task")
+	}
+	if len(saved()) != 2 {
+		t.Fatal("failed remove changed saved tasks")
+	}
+	if err := runTaskCmd(t, path, taskRemoveCmd, "", nil, "t1"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if tasks = saved(); len(tasks) != 1 || tasks[0].Name != "t2" {
+		t.Fatalf("unexpected tasks after remove: %v", tasks)
+	}
+}
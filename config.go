@@ -19,193 +19,46 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"strings"
-)
-
-type tracerConfigOption func(tkconf *taskConfig)
-
-func tracerWithName(name string) tracerConfigOption {
-	return func(tkconf *taskConfig) {
-		tkconf.Name = name
-	}
-}
-
-func tracerWithTracer(tracer string) tracerConfigOption {
-	return func(tkconf *taskConfig) {
-		tkconf.Tracer = tracer
-	}
-}
-
-func tracerWithVersion(version string) tracerConfigOption {
-	return func(tkconf *taskConfig) {
-		tkconf.Version = version
-	}
-}
-
-func tracerWithRoute(path string) tracerConfigOption {
-	return func(tkconf *taskConfig) {
-		tkconf.RouteConfig = path
-	}
-}
-
-func tracerWithAmplifier(threads, repeat int) tracerConfigOption {
-	return func(tkconf *taskConfig) {
-		tkconf.SendThreads = threads
-		tkconf.SendTimesPerThread = repeat
-	}
-}
-
-func tracerWithCollector(proto string, ip string, port int, path string) tracerConfigOption {
-	return func(tkconf *taskConfig) {
-		tkconf.CollectorProto = proto
-		tkconf.CollectorIP = ip
-		tkconf.CollectorPort = port
-		tkconf.CollectorPath = path
-	}
-}
-
-type taskConfig struct {
-	Name               string `json:"name"`
-	Tracer             string `json:"tracer"`
-	Version            string `json:"version"`
-	RouteConfig        string `json:"route_config"`
-	SendThreads        int    `json:"send_threads"`
-	SendTimesPerThread int    `json:"send_times_per_thread"`
-	CollectorProto     string `json:"collector_proto"`
-	CollectorIP        string `json:"collector_ip"`
-	CollectorPort      int    `json:"collector_port"`
-	CollectorPath      string `json:"collector_path"`
-}
-
-func (tkconf *taskConfig) With(opts ...tracerConfigOption) *taskConfig {
-	for _, opt := range opts {
-		opt(tkconf)
-	}
-
-	return tkconf
-}
-
-func (tkconf *taskConfig) Print() {
-	log.Println("------")
-	log.Printf("Name: %s", tkconf.Name)
-	log.Printf("Tracer: %s", tkconf.Tracer)
-	log.Printf("Version: %s", tkconf.Version)
-	log.Printf("Route: %s", tkconf.RouteConfig)
-	log.Printf("Threads: %d Repeated: %d", tkconf.SendThreads, tkconf.SendTimesPerThread)
-	log.Printf("Collector: <%s://%s:%d%s>", tkconf.CollectorProto, tkconf.CollectorIP, tkconf.CollectorPort, tkconf.CollectorPath)
-}
-
-func NewTaskConfig(opts ...tracerConfigOption) *taskConfig {
-	tkconf := &taskConfig{}
-	for _, opt := range opts {
-		opt(tkconf)
-	}
-
-	return tkconf
-}
-
-type benchConfigOption func(bconf *benchConfig)
-
-func benchWithLog(enable bool) benchConfigOption {
-	return func(bconf *benchConfig) {
-		bconf.DisableLog = enable
-	}
-}
-
-func benchWithTasks(tasks ...*taskConfig) benchConfigOption {
-	return func(bconf *benchConfig) {
-		for _, new := range tasks {
-			found := false
-			for _, origin := range bconf.Tasks {
-				if origin.Name == new.Name {
-					origin = new
-					found = true
-					break
-				}
-			}
-			if !found {
-				bconf.Tasks = append(bconf.Tasks, new)
-			}
-		}
-	}
-}
-
-type benchConfig struct {
-	DisableLog bool          `json:"disable_log"`
-	Tasks      []*taskConfig `json:"tasks"`
-}
-
-func (bconf *benchConfig) With(opts ...benchConfigOption) *benchConfig {
-	for _, opt := range opts {
-		opt(bconf)
-	}
-
-	return bconf
-}
-
-func (bconf *benchConfig) Print() {
-	log.Println("trace benchmark config:")
-	log.Println("### ### ###")
-	if bconf.DisableLog {
-		log.Println("log: disabled")
-	} else {
-		log.Println("log: enabled")
-	}
-	for _, tkconf := range bconf.Tasks {
-		tkconf.Print()
-	}
-}
-
-func newBenchmarkConfig(opts ...benchConfigOption) *benchConfig {
-	dconfig := &benchConfig{}
-	for _, opt := range opts {
-		opt(dconfig)
-	}
 
-	return dconfig
-}
-
-const (
-	dd   string = "ddtrace"
-	jg   string = "jaeger"
-	otel string = "open-telemetry"
-	pp   string = "pinpoint"
-	sky  string = "skywalking"
-	zpk  string = "zipkin"
+	"github.com/CodapeWild/dktrace-data-benchmark/bench"
+	"github.com/CodapeWild/dktrace-data-benchmark/tracer"
 )
 
 var (
-	tracers = map[string]bool{
-		dd:   true,
-		jg:   true,
-		otel: true,
-		pp:   true,
-		sky:  true,
-		zpk:  true,
-	}
-	envs       = []string{"DKTRACE_CONFIG", "DKTRACE_DISABLE_LOG", "DKTRACE_TASKS"}
-	gBenchConf *benchConfig
-	gTasks     []*taskConfig
+	envs       = []string{"DKTRACE_CONFIG", "DKTRACE_LOG_LEVEL", "DKTRACE_DISABLE_LOG", "DKTRACE_TASKS", "DKTRACE_WORKER_TOKEN"}
+	gBenchConf *bench.BenchConfig
+	gEnvTasks  []*bench.TaskConfig
 )
 
-// default configurations
+// default configurations, environment variables override them and flags override both
 var (
-	defBenchConf  = "./config.json"
-	defDisableLog = false
-	defTask       = &taskConfig{
-		Name:               "default",
-		Tracer:             "v0.4",
-		Version:            "ddtrace",
+	defBenchConf = "./config.json"
+	defLogLevel  = "info"
+	defTask      = &bench.TaskConfig{
+		Tracer:             bench.DDTrace,
 		RouteConfig:        "./routes/user-login.json",
 		SendThreads:        3,
 		SendTimesPerThread: 10,
 		CollectorProto:     "http",
 		CollectorIP:        "127.0.0.1",
 		CollectorPort:      9529,
-		CollectorPath:      "/v0.4/traces",
 	}
+	// token kept out of command line where it is visible to other users of host
+	defWorkerToken = ""
+)
+
+// log levels accepted by --log-level
+const (
+	logDebug = "debug"
+	logInfo  = "info"
+	logOff   = "off"
 )
 
 func loadEnvVariables() {
@@ -217,71 +70,85 @@ func loadEnvVariables() {
 		switch key {
 		case "DKTRACE_CONFIG":
 			defBenchConf = v
+		case "DKTRACE_LOG_LEVEL":
+			defLogLevel = v
 		case "DKTRACE_DISABLE_LOG":
 			if b := strings.ToLower(v); b == "true" {
-				defDisableLog = true
+				defLogLevel = logOff
 			}
+		case "DKTRACE_WORKER_TOKEN":
+			defWorkerToken = v
 		case "DKTRACE_TASKS":
-			var tasks = &[]*taskConfig{}
+			var tasks = &[]*bench.TaskConfig{}
 			if err := json.Unmarshal([]byte(v), tasks); err != nil {
 				log.Println(err.Error())
 			} else {
-				for _, task := range *tasks {
-					gTasks = append(gTasks, task)
-				}
+				gEnvTasks = append(gEnvTasks, *tasks...)
 			}
 		}
 	}
 }
 
-func loadBenchConfigFile(path string) (*benchConfig, error) {
-	bts, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+// loadConfig loads configuration file at path merged with tasks from environment, a missing
+// file is an empty configuration so that tasks can be added into a new one
+func loadConfig(path string) error {
+	var (
+		bconf *bench.BenchConfig
+		err   error
+	)
+	if _, err = os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		bconf = bench.NewBenchmarkConfig()
+	} else if bconf, err = bench.LoadBenchConfigFile(path); err != nil {
+		return err
 	}
-
-	var benchConf benchConfig
-	if err = json.Unmarshal(bts, &benchConf); err != nil {
-		return nil, err
+	if len(gEnvTasks) != 0 {
+		bconf.MergeEnvTasks(gEnvTasks)
 	}
+	gBenchConf = bconf
 
-	return &benchConf, nil
+	return nil
 }
 
-func mergeTasks(dst *[]*taskConfig, src []*taskConfig) {
-	for _, s := range src {
-		found := false
-		for _, d := range *dst {
-			if d.Name == s.Name {
-				found = true
-				d = s
-				break
-			}
-		}
-		if !found {
-			*dst = append(*dst, s)
-		}
-	}
+// saveConfig writes tasks changed by commands back into configuration file
+func saveConfig(path string) error {
+	return bench.DumpBenchConfigFile(path, gBenchConf)
 }
 
-func dumpBenchConfigFile(path string, benchConf *benchConfig) error {
-	bts, err := json.Marshal(benchConf)
-	if err != nil {
-		return err
+func setLogLevel(level string) error {
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.LstdFlags)
+	tracer.SetDebug(false)
+	switch level {
+	case logDebug:
+		log.SetFlags(log.Lshortfile | log.LstdFlags)
+		tracer.SetDebug(true)
+	case logInfo:
+	case logOff:
+		log.SetOutput(io.Discard)
+	default:
+		return fmt.Errorf("unsupported log level: %s", level)
 	}
 
-	return os.WriteFile(defBenchConf, bts, 0644)
+	return nil
 }
 
-// exec config procedure
-func init() {
-	loadEnvVariables()
-	var err error
-	gBenchConf, err = loadBenchConfigFile(defBenchConf)
+// copyTask returns a deep copy of task sharing no maps, slices or pointers with it
+func copyTask(task *bench.TaskConfig) (*bench.TaskConfig, error) {
+	buf, err := json.Marshal(task)
 	if err != nil {
-		log.Fatalln(err.Error())
+		return nil, err
 	}
-	if len(gTasks) != 0 {
-		mergeTasks(&gBenchConf.Tasks, gTasks)
+	cp := &bench.TaskConfig{}
+
+	return cp, json.Unmarshal(buf, cp)
+}
+
+func findTask(name string) (int, *bench.TaskConfig) {
+	for i, task := range gBenchConf.Tasks {
+		if task.Name == name {
+			return i, task
+		}
 	}
+
+	return -1, nil
 }
@@ -15,19 +15,27 @@
  *   limitations under the License.
  */
 
-package main
+// Package fixtures embeds payloads recorded from tracer library versions, one archive of
+// each version named <protocol>/<version>.jsonl.
+package fixtures
 
 import (
-	"context"
+	"embed"
+	"io/fs"
 )
 
-type Tracer interface {
-	Start(agentAddress, service string)
-	StartSpan(ctx context.Context) (Span, context.Context)
-	Stop()
-}
+//go:embed ddtrace jaeger
+var root embed.FS
+
+// Protocol returns fixtures of protocol, nil if none shipped
+func Protocol(protocol string) fs.FS {
+	if _, err := fs.Stat(root, protocol); err != nil {
+		return nil
+	}
+	sub, err := fs.Sub(root, protocol)
+	if err != nil {
+		return nil
+	}
 
-type Span interface {
-	SetTag(key string, value interface{})
-	EndSpan()
+	return sub
 }
@@ -0,0 +1,155 @@
+/*
+*   Copyright (c) 2023 CodapeWild
+*   All rights reserved.
+
+*   Licensed under the Apache License, Version 2.0 (the "License");
+*   you may not use this file except in compliance with the License.
+*   You may obtain a copy of the License at
+
+*   http://www.apache.org/licenses/LICENSE-2.0
+
+*   Unless required by applicable law or agreed to in writing, software
+*   distributed under the License is distributed on an "AS IS" BASIS,
+*   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+*   See the License for the specific language governing permissions and
+*   limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// otelTracer exports spans of the OTel SDK in one batch through the Jaeger exporter, as
+// tracer.JgTracerWrapper of the benchmark sends through jaeger-client-go
+type otelTracer struct {
+	tp *sdktrace.TracerProvider
+	tr oteltrace.Tracer
+}
+
+func newTracer() routeTracer { return &otelTracer{} }
+
+func (o *otelTracer) Start(addr, service string) {
+	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint("http://" + addr + "/apis/traces")))
+	if err != nil {
+		log.Fatal(err)
+	}
+	o.tp = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))))
+	o.tr = o.tp.Tracer("dktrace-data-benchmark")
+}
+
+func (o *otelTracer) StartSpan(ctx context.Context, operation string) (routeSpan, context.Context) {
+	ctx, span := o.tr.Start(ctx, operation)
+
+	return otelSpan{span}, ctx
+}
+
+func (o *otelTracer) Stop() {
+	if err := o.tp.Shutdown(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type otelSpan struct{ oteltrace.Span }
+
+func (s otelSpan) SetTag(k string, v interface{}) {
+	switch v := v.(type) {
+	case int:
+		s.Span.SetAttributes(attribute.Int(k, v))
+	case string:
+		s.Span.SetAttributes(attribute.String(k, v))
+	}
+}
+
+func (s otelSpan) EndSpan() { s.Span.End() }
+
+type call struct {
+	ID       int  `json:"id"`
+	Outgoing bool `json:"outgoing"`
+}
+
+type hop struct {
+	ID      int     `json:"id"`
+	Name    string  `json:"name"`
+	Action  string  `json:"action"`
+	Status  string  `json:"status"`
+	Message string  `json:"message"`
+	Calls   []*call `json:"calls"`
+}
+
+// spawn mirrors spans spawned by route of the benchmark, with the same operations, tags and
+// minimum duration, without importing it
+func spawn(ctx context.Context, tr routeTracer, hops map[int]*hop, id int, service string) {
+	op := hops[id]
+	if service == "" {
+		service = op.Name
+	}
+	start := time.Now()
+	span, ctx := tr.StartSpan(ctx, op.Action)
+	defer func() {
+		if time.Since(start) < 30*time.Millisecond {
+			time.Sleep(30 * time.Millisecond)
+		}
+		span.EndSpan()
+	}()
+	span.SetTag("id", op.ID)
+	span.SetTag("service", service)
+	span.SetTag("name", op.Name)
+	span.SetTag("action", op.Action)
+	span.SetTag("status", op.Status)
+	span.SetTag("message", op.Message)
+	for _, c := range op.Calls {
+		if c.Outgoing {
+			spawn(ctx, tr, hops, c.ID, "")
+		} else {
+			spawn(ctx, tr, hops, c.ID, service)
+		}
+	}
+}
+
+// routeTracer is tracer.Tracer of the benchmark with the operation passed explicitly
+type routeTracer interface {
+	Start(addr, service string)
+	StartSpan(ctx context.Context, operation string) (routeSpan, context.Context)
+	Stop()
+}
+
+type routeSpan interface {
+	SetTag(key string, value interface{})
+	EndSpan()
+}
+
+func main() {
+	addr := flag.String("addr", "", "agent address")
+	path := flag.String("route", "", "route file")
+	flag.Parse()
+
+	bts, err := os.ReadFile(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var route []*hop
+	if err = json.Unmarshal(bts, &route); err != nil {
+		log.Fatal(err)
+	}
+	hops := make(map[int]*hop)
+	for _, op := range route {
+		hops[op.ID] = op
+	}
+	tr := newTracer()
+	tr.Start(*addr, route[0].Name)
+	spawn(context.Background(), tr, hops, route[0].ID, "")
+	tr.Stop()
+}
@@ -0,0 +1,273 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+// Package format reads and writes JSON, YAML and TOML documents chosen by file extension
+// into values tagged for encoding/json, so that every format shares the same field names.
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// formats of documents
+const (
+	JSON = "json"
+	YAML = "yaml"
+	TOML = "toml"
+)
+
+// Of returns format of path by extension, JSON if unknown
+func Of(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML
+	case ".toml":
+		return TOML
+	default:
+		return JSON
+	}
+}
+
+// Decode decodes document of path into a tree of map[string]interface{}, []interface{}
+// and scalars
+func Decode(path string, data []byte) (interface{}, error) {
+	var (
+		tree interface{}
+		err  error
+	)
+	switch Of(path) {
+	case YAML:
+		err = yaml.Unmarshal(data, &tree)
+	case TOML:
+		var m map[string]interface{}
+		err = toml.Unmarshal(data, &m)
+		tree = m
+	default:
+		err = json.Unmarshal(data, &tree)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	return normalize(tree), nil
+}
+
+// Encode encodes tree into document of path
+func Encode(path string, tree interface{}) ([]byte, error) {
+	switch Of(path) {
+	case YAML:
+		return yaml.Marshal(tree)
+	case TOML:
+		buf := &bytes.Buffer{}
+		if err := toml.NewEncoder(buf).Encode(dropNil(tree)); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	default:
+		return json.MarshalIndent(tree, "", "  ")
+	}
+}
+
+// Unmarshal decodes document of path into v
+func Unmarshal(path string, data []byte, v interface{}) error {
+	tree, err := Decode(path, data)
+	if err != nil {
+		return err
+	}
+
+	return FromTree(tree, v)
+}
+
+// Marshal encodes v into document of path
+func Marshal(path string, v interface{}) ([]byte, error) {
+	tree, err := ToTree(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return Encode(path, tree)
+}
+
+// ToTree converts v into tree by its JSON encoding, integers are kept as int64
+func ToTree(v interface{}) (interface{}, error) {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		tree interface{}
+		dec  = json.NewDecoder(bytes.NewReader(bts))
+	)
+	dec.UseNumber()
+	if err = dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	return normalize(tree), nil
+}
+
+// FromTree converts tree into v by JSON encoding. Strings are parsed where v expects numbers
+// or booleans, so that values interpolated into strings keep the type of their fields.
+func FromTree(tree interface{}, v interface{}) error {
+	tree, err := coerce(tree, reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+	bts, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bts, v)
+}
+
+func normalize(tree interface{}) interface{} {
+	switch t := tree.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			t[k] = normalize(v)
+		}
+
+		return t
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = normalize(v)
+		}
+
+		return m
+	case []interface{}:
+		for i, v := range t {
+			t[i] = normalize(v)
+		}
+
+		return t
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+
+		return f
+	case []map[string]interface{}:
+		// TOML array of tables
+		list := make([]interface{}, len(t))
+		for i, v := range t {
+			list[i] = normalize(v)
+		}
+
+		return list
+	default:
+		return tree
+	}
+}
+
+func dropNil(tree interface{}) interface{} {
+	switch t := tree.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			if v == nil {
+				delete(t, k)
+			} else {
+				t[k] = dropNil(v)
+			}
+		}
+	case []interface{}:
+		for i, v := range t {
+			t[i] = dropNil(v)
+		}
+	}
+
+	return tree
+}
+
+func coerce(tree interface{}, typ reflect.Type) (interface{}, error) {
+	if typ == nil || tree == nil {
+		return tree, nil
+	}
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch t := tree.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			var ft reflect.Type
+			switch typ.Kind() {
+			case reflect.Struct:
+				ft = fieldByJSONName(typ, k)
+			case reflect.Map:
+				ft = typ.Elem()
+			}
+			cv, err := coerce(v, ft)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", k, err.Error())
+			}
+			t[k] = cv
+		}
+	case []interface{}:
+		if typ.Kind() != reflect.Slice && typ.Kind() != reflect.Array {
+			return tree, nil
+		}
+		for i, v := range t {
+			cv, err := coerce(v, typ.Elem())
+			if err != nil {
+				return nil, err
+			}
+			t[i] = cv
+		}
+	case string:
+		switch typ.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.ParseInt(t, 10, 64)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return strconv.ParseUint(t, 10, 64)
+		case reflect.Float32, reflect.Float64:
+			return strconv.ParseFloat(t, 64)
+		case reflect.Bool:
+			return strconv.ParseBool(t)
+		}
+	}
+
+	return tree, nil
+}
+
+func fieldByJSONName(typ reflect.Type, name string) reflect.Type {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag == name || (tag == "" && strings.EqualFold(f.Name, name)) {
+			return f.Type
+		}
+	}
+
+	return nil
+}
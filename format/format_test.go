@@ -0,0 +1,155 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package format
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testTarget struct {
+	Name    string            `json:"name"`
+	Port    int               `json:"port"`
+	Size    uint              `json:"size"`
+	Ratio   float64           `json:"ratio"`
+	Enabled bool              `json:"enabled"`
+	Limit   *int              `json:"limit,omitempty"`
+	Ports   []int             `json:"ports,omitempty"`
+	Weights map[string]int    `json:"weights,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Items   []*testTarget     `json:"items,omitempty"`
+}
+
+func TestOf(t *testing.T) {
+	for path, expect := range map[string]string{
+		"config.json": JSON,
+		"config.yaml": YAML,
+		"config.YML":  YAML,
+		"config.toml": TOML,
+		"config":      JSON,
+		"config.conf": JSON,
+	} {
+		if got := Of(path); got != expect {
+			t.Fatalf("%s: expect %s got %s", path, expect, got)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	docs := map[string]string{
+		"config.json": `{"name": "a", "port": 9529, "ratio": 0.5, "enabled": true, "items": [{"name": "b", "port": 1}]}`,
+		"config.yaml": "name: a\nport: 9529\nratio: 0.5\nenabled: true\nitems:\n  - name: b\n    port: 1\n",
+		"config.toml": "name = \"a\"\nport = 9529\nratio = 0.5\nenabled = true\n\n[[items]]\nname = \"b\"\nport = 1\n",
+	}
+	var expect interface{}
+	for path, doc := range docs {
+		tree, err := Decode(path, []byte(doc))
+		if err != nil {
+			t.Fatalf("%s: %s", path, err.Error())
+		}
+		if items, ok := tree.(map[string]interface{})["items"].([]interface{}); !ok || len(items) != 1 {
+			t.Fatalf("%s: expect items decoded as list got %T", path, tree.(map[string]interface{})["items"])
+		}
+		// numbers decoded differently by formats are equal once converted
+		var got testTarget
+		if err = FromTree(tree, &got); err != nil {
+			t.Fatalf("%s: %s", path, err.Error())
+		}
+		if expect == nil {
+			expect = got
+		} else if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("%s: expect %+v got %+v", path, expect, got)
+		}
+	}
+
+	if _, err := Decode("config.yaml", []byte("name: [a")); err == nil || !strings.HasPrefix(err.Error(), "config.yaml: ") {
+		t.Fatalf("expect error prefixed by path got %v", err)
+	}
+}
+
+func TestFromTree(t *testing.T) {
+	cases := []struct {
+		path string
+		doc  string
+		ok   bool
+	}{
+		// strings, as interpolated values are, take the type of their fields
+		{"config.yaml", "port: \"9529\"\nsize: \"3\"\nratio: \"0.5\"\nenabled: \"true\"\nlimit: \"10\"\nports: [\"1\", \"2\"]\nweights: {a: \"1\"}\nlabels: {a: \"1\"}\nitems: [{port: \"1\"}]\n", true},
+		{"config.toml", "port = \"9529\"\nsize = \"3\"\nratio = \"0.5\"\nenabled = \"true\"\nlimit = \"10\"\nports = [\"1\", \"2\"]\n\n[weights]\na = \"1\"\n\n[labels]\na = \"1\"\n\n[[items]]\nport = \"1\"\n", true},
+		{"config.json", `{"port": "9529", "size": "3", "ratio": "0.5", "enabled": "true", "limit": "10", "ports": ["1", "2"], "weights": {"a": "1"}, "labels": {"a": "1"}, "items": [{"port": "1"}]}`, true},
+		{"config.yaml", "port: port\n", false},
+		{"config.toml", "size = \"-1\"\n", false},
+		{"config.json", `{"enabled": "yes"}`, false},
+		{"config.json", `{"items": [{"ratio": "half"}]}`, false},
+	}
+	for _, c := range cases {
+		var target testTarget
+		err := Unmarshal(c.path, []byte(c.doc), &target)
+		if (err == nil) != c.ok {
+			t.Fatalf("%s %q: expect ok %v got error %v", c.path, c.doc, c.ok, err)
+		}
+		if err != nil {
+			continue
+		}
+		if target.Port != 9529 || target.Size != 3 || target.Ratio != 0.5 || !target.Enabled || target.Limit == nil || *target.Limit != 10 {
+			t.Fatalf("%s: unexpected scalars: %+v", c.path, target)
+		}
+		if !reflect.DeepEqual(target.Ports, []int{1, 2}) || target.Weights["a"] != 1 || target.Labels["a"] != "1" || len(target.Items) != 1 || target.Items[0].Port != 1 {
+			t.Fatalf("%s: unexpected collections: %+v", c.path, target)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	limit := 10
+	src := &testTarget{
+		Name:    "a",
+		Port:    9529,
+		Size:    3,
+		Ratio:   0.5,
+		Enabled: true,
+		Limit:   &limit,
+		Ports:   []int{1, 2},
+		Weights: map[string]int{"a": 1},
+		Labels:  map[string]string{"a": "1"},
+		Items:   []*testTarget{{Name: "b", Port: 1}},
+	}
+	for _, path := range []string{"config.json", "config.yaml", "config.toml"} {
+		bts, err := Marshal(path, src)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err.Error())
+		}
+		var dst testTarget
+		if err = Unmarshal(path, bts, &dst); err != nil {
+			t.Fatalf("%s: %s", path, err.Error())
+		}
+		if !reflect.DeepEqual(&dst, src) {
+			t.Fatalf("%s: expect %+v got %+v", path, src, &dst)
+		}
+	}
+
+	// TOML has no null, nil values are left out
+	bts, err := Encode("config.toml", map[string]interface{}{"name": "a", "limit": nil, "item": map[string]interface{}{"port": nil}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if strings.Contains(string(bts), "limit") || strings.Contains(string(bts), "port") {
+		t.Fatalf("expect nil values dropped: %s", bts)
+	}
+}
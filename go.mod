@@ -3,12 +3,17 @@ module github.com/CodapeWild/dktrace-data-benchmark
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.2.0
 	github.com/CodapeWild/devkit v0.0.0-20230810114359-06f2a041b590
 	github.com/DataDog/datadog-agent/pkg/trace v0.44.1
+	github.com/klauspost/compress v1.16.7
 	github.com/opentracing/opentracing-go v1.2.0
 	github.com/spf13/cobra v1.7.0
+	github.com/spf13/pflag v1.0.5
 	github.com/uber/jaeger-client-go v2.30.0+incompatible
+	golang.org/x/net v0.9.0
 	gopkg.in/DataDog/dd-trace-go.v1 v1.50.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -32,13 +37,13 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rogpeppe/go-internal v1.10.0 // indirect
 	github.com/secure-systems-lab/go-securesystemslib v0.5.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/tinylib/msgp v1.1.6 // indirect
 	github.com/uber/jaeger-lib v2.4.1+incompatible // indirect
 	go.uber.org/atomic v1.10.0 // indirect
 	go4.org/intern v0.0.0-20211027215823-ae77deb06f29 // indirect
 	go4.org/unsafe/assume-no-moving-gc v0.0.0-20220617031537-928513b29760 // indirect
 	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
 	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
@@ -0,0 +1,216 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+	"github.com/CodapeWild/dktrace-data-benchmark/bench"
+)
+
+const (
+	progressRefresh  = time.Second
+	progressSummary  = 10 * time.Second
+	progressBarWidth = 30
+	progressLogLines = 5
+)
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// logTail keeps the last lines written by log while progress view owns the terminal
+type logTail struct {
+	sync.Mutex
+	lines []string
+}
+
+func (lt *logTail) Write(p []byte) (int, error) {
+	lt.Lock()
+	defer lt.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		lt.lines = append(lt.lines, line)
+	}
+	if l := len(lt.lines); l > progressLogLines {
+		lt.lines = append([]string(nil), lt.lines[l-progressLogLines:]...)
+	}
+
+	return len(p), nil
+}
+
+func (lt *logTail) tail() []string {
+	lt.Lock()
+	defer lt.Unlock()
+
+	return append([]string(nil), lt.lines...)
+}
+
+// progressView renders progress of running tasks once per second on terminal,
+// or logs a summary line per task periodically otherwise
+type progressView struct {
+	tty  bool
+	out  io.Writer
+	logs *logTail
+	// log output restored after stopped
+	origin io.Writer
+	prev   map[string]*agent.Stats
+	last   time.Time
+	lines  int
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func startProgressView() *progressView {
+	pv := &progressView{
+		tty:  isTerminal(os.Stdout),
+		out:  os.Stdout,
+		prev: make(map[string]*agent.Stats),
+		last: time.Now(),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if pv.tty {
+		pv.logs = &logTail{}
+		pv.origin = log.Writer()
+		log.SetOutput(pv.logs)
+	}
+	go pv.run()
+
+	return pv
+}
+
+func (pv *progressView) run() {
+	defer close(pv.done)
+
+	interval := progressSummary
+	if pv.tty {
+		interval = progressRefresh
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pv.stop:
+			pv.refresh()
+
+			return
+		case <-ticker.C:
+			pv.refresh()
+		}
+	}
+}
+
+// Stop renders the last time and gives terminal back to log
+func (pv *progressView) Stop() {
+	close(pv.stop)
+	<-pv.done
+	if pv.tty {
+		log.SetOutput(pv.origin)
+	}
+}
+
+func (pv *progressView) refresh() {
+	var (
+		now     = time.Now()
+		elapsed = now.Sub(pv.last).Seconds()
+		tms     = gMetrics.Snapshot()
+		buf     = &bytes.Buffer{}
+	)
+	pv.last = now
+	for _, tm := range tms {
+		// tasks seen the first time are rated since view started
+		var (
+			rate = 0.0
+			sent = tm.Stats.Requests
+		)
+		if prev, ok := pv.prev[tm.Task]; ok {
+			sent -= prev.Requests
+		}
+		if elapsed > 0 && sent > 0 {
+			rate = float64(sent) / elapsed
+		}
+		pv.prev[tm.Task] = tm.Stats
+
+		line := progressLine(tm, rate)
+		if pv.tty {
+			fmt.Fprintln(buf, line)
+		} else {
+			log.Println(line)
+		}
+	}
+	if !pv.tty {
+		return
+	}
+
+	if tail := pv.logs.tail(); len(tail) != 0 {
+		fmt.Fprintln(buf, "--- recent logs ---")
+		for _, line := range tail {
+			fmt.Fprintln(buf, line)
+		}
+	}
+	// move cursor back over the previous rendering and clear it
+	if pv.lines > 0 {
+		fmt.Fprintf(pv.out, "\033[%dA\033[J", pv.lines)
+	}
+	pv.lines = strings.Count(buf.String(), "\n")
+	pv.out.Write(buf.Bytes())
+}
+
+func progressLine(tm *bench.TaskMetrics, rate float64) string {
+	var (
+		st = tm.Stats
+		// payloads done, retries are requests of payloads done already
+		done    = st.Requests - st.Retries + st.QueueDropped
+		percent = 0.0
+	)
+	if st.Planned > 0 {
+		percent = float64(done) / float64(st.Planned)
+		if percent > 1 {
+			percent = 1
+		}
+	}
+	filled := int(percent * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+
+	return fmt.Sprintf("%s (%s) [%s] %d/%d %3.0f%% %.1f req/s eta: %s p50: %s p90: %s p99: %s errors: %d",
+		tm.Task, tm.Tracer, bar, done, st.Planned, percent*100, rate, progressETA(st.Planned-done, rate),
+		st.Latency.Quantile(0.5).Round(time.Microsecond), st.Latency.Quantile(0.9).Round(time.Microsecond), st.Latency.Quantile(0.99).Round(time.Microsecond), st.Errors)
+}
+
+// progressETA estimates time left for remaining requests at current rate, unknown if stalled
+func progressETA(remaining int64, rate float64) string {
+	if remaining <= 0 {
+		return "0s"
+	}
+	if rate <= 0 {
+		return "-"
+	}
+
+	return time.Duration(float64(remaining) / rate * float64(time.Second)).Round(time.Second).String()
+}
@@ -0,0 +1,119 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+	"github.com/CodapeWild/dktrace-data-benchmark/bench"
+)
+
+func TestProgressLine(t *testing.T) {
+	cases := []struct {
+		requests, retries, queueDropped, planned, errors int64
+		rate                                             float64
+		expect                                           []string
+	}{
+		{0, 0, 0, 100, 0, 0, []string{"[" + strings.Repeat("-", progressBarWidth) + "]", " 0/100 ", "  0%", "0.0 req/s", "eta: -", "errors: 0"}},
+		// retries are requests of payloads done already, payloads dropped by queue are done
+		{50, 10, 5, 100, 3, 5, []string{"[" + strings.Repeat("#", 13) + strings.Repeat("-", 17) + "]", " 45/100 ", " 45%", "5.0 req/s", "eta: 11s", "errors: 3"}},
+		{120, 0, 0, 100, 0, 12.25, []string{"[" + strings.Repeat("#", progressBarWidth) + "]", " 120/100 ", "100%", "12.2 req/s", "eta: 0s"}},
+		{10, 0, 0, 0, 0, 1, []string{" 10/0 ", "  0%", "eta: 0s"}},
+	}
+	for i, c := range cases {
+		st := agent.NewStats()
+		st.Requests, st.Retries, st.QueueDropped, st.Planned, st.Errors = c.requests, c.retries, c.queueDropped, c.planned, c.errors
+		st.Latency.Observe(3 * time.Millisecond)
+		line := progressLine(&bench.TaskMetrics{Task: "task", Tracer: "ddtrace", Stats: st}, c.rate)
+		if !strings.HasPrefix(line, "task (ddtrace) [") || !strings.Contains(line, " p50: ") {
+			t.Fatalf("case %d: unexpected line: %s", i, line)
+		}
+		for _, s := range c.expect {
+			if !strings.Contains(line, s) {
+				t.Fatalf("case %d: expect %q in line: %s", i, s, line)
+			}
+		}
+	}
+}
+
+func TestLogTail(t *testing.T) {
+	lt := &logTail{}
+	if len(lt.tail()) != 0 {
+		t.Fatal("expect empty tail")
+	}
+	lt.Write([]byte("line 0\n"))
+	lt.Write([]byte("line 1\nline 2\n"))
+	if tail := lt.tail(); len(tail) != 3 || tail[0] != "line 0" || tail[2] != "line 2" {
+		t.Fatalf("unexpected tail: %q", tail)
+	}
+
+	buf := &bytes.Buffer{}
+	for i := 3; i < 3+2*progressLogLines; i++ {
+		fmt.Fprintf(buf, "line %d\n", i)
+	}
+	if n, err := lt.Write(buf.Bytes()); err != nil || n != buf.Len() {
+		t.Fatalf("unexpected write: %d %v", n, err)
+	}
+	tail := lt.tail()
+	if len(tail) != progressLogLines {
+		t.Fatalf("expect %d lines got %q", progressLogLines, tail)
+	}
+	for i, line := range tail {
+		if expect := fmt.Sprintf("line %d", 3+progressLogLines+i); line != expect {
+			t.Fatalf("expect %s got %s", expect, line)
+		}
+	}
+	// tail returned is a copy
+	tail[0] = "changed"
+	if lt.tail()[0] == "changed" {
+		t.Fatal("tail shares lines with log tail")
+	}
+}
+
+func TestProgressRefresh(t *testing.T) {
+	st := agent.NewStats()
+	st.Planned = 1000
+	gMetrics.Register("progress-refresh", "ddtrace", st)
+
+	buf := &bytes.Buffer{}
+	pv := &progressView{tty: true, out: buf, logs: &logTail{}, prev: make(map[string]*agent.Stats), last: time.Now()}
+	pv.logs.Write([]byte("recent log\n"))
+	pv.refresh()
+	first := buf.String()
+	if !strings.Contains(first, "progress-refresh (ddtrace)") || !strings.HasSuffix(first, "--- recent logs ---\nrecent log\n") {
+		t.Fatalf("unexpected rendering: %q", first)
+	}
+
+	// rate is requests sent since the previous refresh, the previous rendering is cleared first
+	buf.Reset()
+	st.Requests = 100
+	pv.last = time.Now().Add(-10 * time.Second)
+	pv.refresh()
+	second := buf.String()
+	if prefix := fmt.Sprintf("\033[%dA\033[J", strings.Count(first, "\n")); !strings.HasPrefix(second, prefix) {
+		t.Fatalf("expect previous rendering cleared: %q", second)
+	}
+	if !strings.Contains(second, "100/1000") || !strings.Contains(second, "10.0 req/s") {
+		t.Fatalf("unexpected rendering: %q", second)
+	}
+}
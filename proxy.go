@@ -0,0 +1,94 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+	"github.com/CodapeWild/dktrace-data-benchmark/bench"
+)
+
+type proxyConfig struct {
+	Upstream string
+	Archive  string
+	DDTrace  string
+	Jaeger   string
+	OTLP     string
+	// verifies https upstream the same way as tls of task
+	TLS bench.TLSConfig
+}
+
+// runProxy serves until SIGINT or SIGTERM received, archives are closed before return
+func runProxy(pconf *proxyConfig) error {
+	var (
+		listens = map[string]string{
+			agent.ProtocolDDTrace: pconf.DDTrace,
+			agent.ProtocolJaeger:  pconf.Jaeger,
+			agent.ProtocolOTLP:    pconf.OTLP,
+		}
+		archives = make(map[string]*agent.ArchiveWriter)
+	)
+	defer func() {
+		for _, archive := range archives {
+			archive.Close()
+		}
+	}()
+	for protocol, addr := range listens {
+		if addr == "" || protocol == agent.ProtocolOTLP {
+			continue
+		}
+		archive, err := agent.NewArchiveWriter(fmt.Sprintf("%s-%s.jsonl", pconf.Archive, protocol))
+		if err != nil {
+			return err
+		}
+		archives[protocol] = archive
+	}
+	if len(archives) == 0 && pconf.OTLP == "" {
+		return fmt.Errorf("no listening address for proxy")
+	}
+
+	tlsConf, err := agent.NewClientTLSConfig(pconf.TLS.CAFile, pconf.TLS.CertFile, pconf.TLS.KeyFile, pconf.TLS.ServerName, pconf.TLS.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	proxy, err := agent.NewProxy(pconf.Upstream, archives, tlsConf)
+	if err != nil {
+		return err
+	}
+	defer proxy.Close()
+	for protocol, addr := range listens {
+		if addr == "" {
+			continue
+		}
+		log.Printf("proxy %s on %s to %s", protocol, addr, pconf.Upstream)
+		if err = proxy.Start(addr); err != nil {
+			return err
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("proxy stopped by signal: %s", <-sig)
+
+	return nil
+}
@@ -15,25 +15,30 @@
  *   limitations under the License.
  */
 
-package main
+// Package route builds span trees out of route files describing calls among services.
+package route
 
 import (
 	"context"
 	"encoding/json"
 	"log"
 	"os"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/format"
+	"github.com/CodapeWild/dktrace-data-benchmark/tracer"
 )
 
-type hop struct {
+// Hop is a service handling a request, the first hop of Route is the root of span tree
+type Hop struct {
 	ID      int     `json:"id"`
 	Name    string  `json:"name"`
 	Action  string  `json:"action"`
 	Status  string  `json:"status"`
 	Message string  `json:"message"`
-	Calls   []*call `json:"calls"`
+	Calls   []*Call `json:"calls"`
 }
 
-func (op *hop) createNode(service string) *node {
+func (op *Hop) createNode(service string) *node {
 	if service == "" {
 		service = op.Name
 	}
@@ -57,9 +62,10 @@ func (op *hop) createNode(service string) *node {
 	return n
 }
 
-type route []*hop
+// Route lists hops of a request, the first hop is the root of span tree
+type Route []*Hop
 
-func (h route) findOptionkByID(id int) (*hop, bool) {
+func (h Route) findOptionkByID(id int) (*Hop, bool) {
 	for _, op := range h {
 		if op.ID == id {
 			return op, true
@@ -69,8 +75,8 @@ func (h route) findOptionkByID(id int) (*hop, bool) {
 	return nil, false
 }
 
-func (h route) createTree(tracer Tracer) *tree {
-	if len(h) == 0 || tracer == nil {
+func (h Route) CreateTree(tr tracer.Tracer) *Tree {
+	if len(h) == 0 || tr == nil {
 		log.Printf("create tree with empty task or nil tracer")
 
 		return nil
@@ -84,10 +90,10 @@ func (h route) createTree(tracer Tracer) *tree {
 		buildQue = append(buildQue, node.children...)
 	}
 
-	return &tree{root: root, tracer: tracer}
+	return &Tree{root: root, tracer: tr}
 }
 
-func (h route) setNode(uncomplete *node) {
+func (h Route) setNode(uncomplete *node) {
 	op, ok := h.findOptionkByID(uncomplete.id)
 	if !ok {
 		return
@@ -109,19 +115,45 @@ func (h route) setNode(uncomplete *node) {
 	}
 }
 
-func newRouteFromJSONFile(path string) (route, error) {
+// NewRouteFromJSONFile loads route in JSON.
+//
+// Deprecated: use NewRouteFromFile supporting YAML and TOML as well.
+func NewRouteFromJSONFile(path string) (Route, error) {
 	bts, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var h route
+	var h Route
 	err = json.Unmarshal(bts, &h)
 
 	return h, err
 }
 
-type call struct {
+// NewRouteFromFile loads route in JSON, YAML or TOML by extension of path. JSON and YAML
+// routes are lists of hops, TOML routes list them as [[hops]] tables.
+func NewRouteFromFile(path string) (Route, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := format.Decode(path, bts)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := tree.(map[string]interface{}); ok {
+		tree = m["hops"]
+	}
+
+	var h Route
+	err = format.FromTree(tree, &h)
+
+	return h, err
+}
+
+// Call is a call of hop to hop ID, an outgoing call starts a span of the service of the
+// callee, otherwise the callee runs in the service of the caller
+type Call struct {
 	ID       int  `json:"id"`
 	Outgoing bool `json:"outgoing"`
 	service  string
@@ -137,12 +169,13 @@ type node struct {
 	children []*node
 }
 
-type tree struct {
+type Tree struct {
 	root   *node
-	tracer Tracer
+	tracer tracer.Tracer
 }
 
-func (tr *tree) count() int {
+// Count returns spans spawned by the tree
+func (tr *Tree) Count() int {
 	var (
 		nodes = []*node{tr.root}
 		c     = 0
@@ -155,7 +188,8 @@ func (tr *tree) count() int {
 	return c
 }
 
-func (tr *tree) spawn(ctx context.Context, agentAddress string) {
+// Spawn starts tracer towards agentAddress and reports spans of the tree
+func (tr *Tree) Spawn(ctx context.Context, agentAddress string) {
 	if tr.tracer == nil || tr.root == nil {
 		log.Printf("got nil tracer: %v or nil span tree: %v", tr.tracer, tr.root)
 
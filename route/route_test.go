@@ -15,13 +15,18 @@
  *   limitations under the License.
  */
 
-package main
+package route
 
 import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/tracer"
 )
 
 func childrenPrinter(children []*node) string {
@@ -50,11 +55,11 @@ func nodePrinter(node *node) string {
 }
 
 func TestBuildTree(t *testing.T) {
-	tasks, err := newRouteFromJSONFile("./tasks/user-login.json")
+	tasks, err := NewRouteFromJSONFile("../routes/user-login.json")
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
-	tree := tasks.createTree(&DDTracerWrapper{})
+	tree := tasks.CreateTree(&tracer.DDTracerWrapper{})
 	jsonstr := nodePrinter(tree.root)
 	if !json.Valid([]byte(jsonstr)) {
 		log.Fatalln("invalid JSON string")
@@ -62,3 +67,51 @@ func TestBuildTree(t *testing.T) {
 
 	log.Println(nodePrinter(tree.root))
 }
+
+func TestRouteFormats(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		yamlr = filepath.Join(dir, "route.yaml")
+		tomlr = filepath.Join(dir, "route.toml")
+	)
+	err := os.WriteFile(yamlr, []byte(`
+- id: 1
+  name: user-agent
+  calls: [{id: 2, outgoing: true}]
+- id: 2
+  name: auth-server
+  action: /auth
+  status: error
+  message: "The key does not exist"
+`), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = os.WriteFile(tomlr, []byte(`
+[[hops]]
+id = 1
+name = "user-agent"
+calls = [{id = 2, outgoing = true}]
+
+[[hops]]
+id = 2
+name = "auth-server"
+action = "/auth"
+status = "error"
+message = "The key does not exist"
+`), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	yr, err := NewRouteFromFile(yamlr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tr, err := NewRouteFromFile(tomlr)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(yr, tr) || len(yr) != 2 || yr[1].Message != "The key does not exist" || !yr[0].Calls[0].Outgoing {
+		t.Fatalf("YAML and TOML routes differ: %s %s", nodePrinter(yr.CreateTree(&tracer.DDTracerWrapper{}).root), nodePrinter(tr.CreateTree(&tracer.DDTracerWrapper{}).root))
+	}
+}
@@ -15,20 +15,20 @@
  *   limitations under the License.
  */
 
-package main
+package route
 
 import (
 	"context"
 	"time"
-)
 
-type ctxNodeInfoKey struct{}
+	"github.com/CodapeWild/dktrace-data-benchmark/tracer"
+)
 
-func (n *node) spawn(ctx context.Context, tracer Tracer) {
+func (n *node) spawn(ctx context.Context, tr tracer.Tracer) {
 	start := time.Now().UnixNano()
 
-	var span Span
-	span, ctx = tracer.StartSpan(context.WithValue(ctx, ctxNodeInfoKey{}, n))
+	var span tracer.Span
+	span, ctx = tr.StartSpan(tracer.WithOperation(ctx, n.action))
 	defer func() {
 		if time.Now().UnixNano()-start < int64(30*time.Millisecond) {
 			time.Sleep(30 * time.Millisecond)
@@ -44,6 +44,6 @@ func (n *node) spawn(ctx context.Context, tracer Tracer) {
 	span.SetTag("message", n.message)
 
 	for _, c := range n.children {
-		c.spawn(ctx, tracer)
+		c.spawn(ctx, tr)
 	}
 }
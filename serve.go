@@ -0,0 +1,491 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/bench"
+)
+
+// states of a run submitted through control API
+const (
+	runPending  = "pending"
+	runRunning  = "running"
+	runFinished = "finished"
+	runCanceled = "canceled"
+)
+
+type benchRun struct {
+	ID      string          `json:"id"`
+	Tasks   []string        `json:"tasks"`
+	State   string          `json:"state"`
+	Current string          `json:"current,omitempty"`
+	Created time.Time       `json:"created"`
+	Start   time.Time       `json:"start,omitempty"`
+	End     time.Time       `json:"end,omitempty"`
+	Results []*bench.Result `json:"results"`
+	tasks   []*bench.TaskConfig
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// controlServer exposes tasks management and runs through REST API, runs are queued and
+// executed one by one for the same reason as runTaskThread. Canceling a run stops its
+// running task with a partial result and skips pending tasks.
+type controlServer struct {
+	sync.Mutex
+	*http.ServeMux
+	bconf *bench.BenchConfig
+	path  string
+	// results output, overridden by --output
+	output string
+	// pause between tasks of a run
+	cooldown time.Duration
+	runs     map[string]*benchRun
+	queue    chan *benchRun
+	seq      int
+}
+
+func newControlServer(bconf *bench.BenchConfig, path string) *controlServer {
+	srv := &controlServer{
+		ServeMux: http.NewServeMux(),
+		bconf:    bconf,
+		path:     path,
+		output:   bconf.Output,
+		runs:     make(map[string]*benchRun),
+		queue:    make(chan *benchRun, 100),
+	}
+	srv.HandleFunc("/tasks", srv.handleTasks)
+	srv.HandleFunc("/tasks/", srv.handleTask)
+	srv.HandleFunc("/runs", srv.handleRuns)
+	srv.HandleFunc("/runs/", srv.handleRun)
+	srv.Handle("/metrics", gMetrics)
+
+	return srv
+}
+
+func (run *benchRun) canceled() bool {
+	return run.ctx.Err() != nil
+}
+
+func (srv *controlServer) execute() {
+	for run := range srv.queue {
+		srv.Lock()
+		if run.State == runCanceled {
+			srv.Unlock()
+			close(run.done)
+			continue
+		}
+		run.State = runRunning
+		run.Start = time.Now()
+		srv.Unlock()
+
+		for i, task := range run.tasks {
+			if i > 0 {
+				coolDown(run.ctx, srv.cooldown)
+			}
+			if run.canceled() {
+				break
+			}
+			srv.Lock()
+			run.Current = task.Name
+			srv.Unlock()
+			res, _ := gRunner.Run(run.ctx, task)
+			srv.Lock()
+			run.Results = append(run.Results, res)
+			run.Current = ""
+			srv.Unlock()
+		}
+
+		srv.Lock()
+		if run.canceled() {
+			run.State = runCanceled
+		} else {
+			run.State = runFinished
+		}
+		run.cancel()
+		run.End = time.Now()
+		srv.Unlock()
+		close(run.done)
+		if err := bench.DumpResults(srv.output, run.Results); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+func writeJSON(resp http.ResponseWriter, status int, v interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	if err := json.NewEncoder(resp).Encode(v); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+func writeError(resp http.ResponseWriter, status int, err error) {
+	writeJSON(resp, status, map[string]string{"error": err.Error()})
+}
+
+func (srv *controlServer) findTask(name string) (int, *bench.TaskConfig) {
+	for i, task := range srv.bconf.Tasks {
+		if task.Name == name {
+			return i, task
+		}
+	}
+
+	return -1, nil
+}
+
+// saveTasks saves configuration with tasks in place of its tasks, which are replaced only
+// once saved so that tasks served stay as persisted if saving fails
+func (srv *controlServer) saveTasks(tasks []*bench.TaskConfig) error {
+	dump := *srv.bconf
+	dump.Tasks = tasks
+	if err := bench.DumpBenchConfigFile(srv.path, &dump); err != nil {
+		return err
+	}
+	srv.bconf.Tasks = tasks
+
+	return nil
+}
+
+// handleTasks serves GET /tasks and POST /tasks
+func (srv *controlServer) handleTasks(resp http.ResponseWriter, req *http.Request) {
+	srv.Lock()
+	defer srv.Unlock()
+
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(resp, http.StatusOK, srv.bconf.AllTasks())
+	case http.MethodPost:
+		task := &bench.TaskConfig{}
+		if err := json.NewDecoder(req.Body).Decode(task); err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+
+			return
+		}
+		if err := bench.Validate(task); err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+
+			return
+		}
+		for _, found := range srv.bconf.AllTasks() {
+			if found.Name == task.Name {
+				writeError(resp, http.StatusConflict, fmt.Errorf("task: %s already exists", task.Name))
+
+				return
+			}
+		}
+		tasks := srv.bconf.Tasks
+		if err := srv.saveTasks(append(tasks[:len(tasks):len(tasks)], task)); err != nil {
+			writeError(resp, http.StatusInternalServerError, err)
+
+			return
+		}
+		writeJSON(resp, http.StatusCreated, task)
+	default:
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTask serves GET, PUT and DELETE on /tasks/{name}
+func (srv *controlServer) handleTask(resp http.ResponseWriter, req *http.Request) {
+	srv.Lock()
+	defer srv.Unlock()
+
+	name := strings.TrimPrefix(req.URL.Path, "/tasks/")
+	i, task := srv.findTask(name)
+	if task == nil {
+		// tasks expanded from matrices are served but only changed through their matrix
+		for _, found := range srv.bconf.AllTasks() {
+			if found.Name != name {
+				continue
+			}
+			if req.Method == http.MethodGet {
+				writeJSON(resp, http.StatusOK, found)
+			} else {
+				writeError(resp, http.StatusConflict, fmt.Errorf("task: %s is expanded from a matrix, change the matrix instead", name))
+			}
+
+			return
+		}
+		writeError(resp, http.StatusNotFound, fmt.Errorf("task: %s not found", name))
+
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(resp, http.StatusOK, task)
+	case http.MethodPut:
+		update := &bench.TaskConfig{}
+		if err := json.NewDecoder(req.Body).Decode(update); err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+
+			return
+		}
+		update.Name = name
+		if err := bench.Validate(update); err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+
+			return
+		}
+		// replace rather than modify, queued runs keep the config they were submitted with
+		tasks := append([]*bench.TaskConfig(nil), srv.bconf.Tasks...)
+		tasks[i] = update
+		if err := srv.saveTasks(tasks); err != nil {
+			writeError(resp, http.StatusInternalServerError, err)
+
+			return
+		}
+		writeJSON(resp, http.StatusOK, update)
+	case http.MethodDelete:
+		if err := srv.saveTasks(append(srv.bconf.Tasks[:i:i], srv.bconf.Tasks[i+1:]...)); err != nil {
+			writeError(resp, http.StatusInternalServerError, err)
+
+			return
+		}
+		resp.WriteHeader(http.StatusNoContent)
+	default:
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRuns serves GET /runs and POST /runs with body {"tasks": ["name", ...]}
+func (srv *controlServer) handleRuns(resp http.ResponseWriter, req *http.Request) {
+	srv.Lock()
+	defer srv.Unlock()
+
+	switch req.Method {
+	case http.MethodGet:
+		var runs []*benchRun
+		for _, run := range srv.runs {
+			runs = append(runs, run)
+		}
+		sort.Slice(runs, func(i, j int) bool { return runs[i].Created.Before(runs[j].Created) })
+		writeJSON(resp, http.StatusOK, runs)
+	case http.MethodPost:
+		var body struct {
+			Tasks  []string `json:"tasks"`
+			Suites []string `json:"suites"`
+			Tags   []string `json:"tags"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(resp, http.StatusBadRequest, err)
+
+			return
+		}
+		if len(body.Tasks) == 0 && len(body.Suites) == 0 && len(body.Tags) == 0 {
+			writeError(resp, http.StatusBadRequest, fmt.Errorf("no task selected"))
+
+			return
+		}
+		tasks, err := srv.bconf.SelectTasks(body.Tasks, body.Suites, body.Tags)
+		if err != nil {
+			writeError(resp, http.StatusNotFound, err)
+
+			return
+		}
+		srv.seq++
+		run := &benchRun{
+			ID:      strconv.Itoa(srv.seq),
+			tasks:   tasks,
+			State:   runPending,
+			Created: time.Now(),
+			Results: []*bench.Result{},
+			done:    make(chan struct{}),
+		}
+		run.ctx, run.cancel = context.WithCancel(context.Background())
+		for _, task := range tasks {
+			run.Tasks = append(run.Tasks, task.Name)
+		}
+		select {
+		case srv.queue <- run:
+		default:
+			writeError(resp, http.StatusServiceUnavailable, fmt.Errorf("too many runs queued"))
+
+			return
+		}
+		srv.runs[run.ID] = run
+		writeJSON(resp, http.StatusAccepted, run)
+	default:
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRun serves GET and DELETE on /runs/{id}, DELETE cancels the run, and
+// GET /runs/{id}/progress streaming progress as server-sent events until the run ends
+func (srv *controlServer) handleRun(resp http.ResponseWriter, req *http.Request) {
+	var (
+		id       = strings.TrimPrefix(req.URL.Path, "/runs/")
+		progress bool
+	)
+	if strings.HasSuffix(id, "/progress") {
+		id = strings.TrimSuffix(id, "/progress")
+		progress = true
+	}
+
+	srv.Lock()
+	run, ok := srv.runs[id]
+	srv.Unlock()
+	if !ok {
+		writeError(resp, http.StatusNotFound, fmt.Errorf("run: %s not found", id))
+
+		return
+	}
+
+	switch {
+	case progress && req.Method == http.MethodGet:
+		srv.streamProgress(resp, req, run)
+	case req.Method == http.MethodGet:
+		srv.Lock()
+		defer srv.Unlock()
+		writeJSON(resp, http.StatusOK, run)
+	case req.Method == http.MethodDelete:
+		srv.Lock()
+		defer srv.Unlock()
+		if run.State == runPending || run.State == runRunning {
+			run.State = runCanceled
+			run.cancel()
+		}
+		writeJSON(resp, http.StatusOK, run)
+	default:
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type runProgress struct {
+	State    string          `json:"state"`
+	Current  string          `json:"current,omitempty"`
+	Finished int             `json:"finished"`
+	Total    int             `json:"total"`
+	Tasks    []*taskProgress `json:"tasks"`
+}
+
+type taskProgress struct {
+	Task     string  `json:"task"`
+	Tracer   string  `json:"tracer"`
+	Requests int64   `json:"requests"`
+	Planned  int64   `json:"planned"`
+	Spans    int64   `json:"spans"`
+	Errors   int64   `json:"errors"`
+	InFlight int64   `json:"in_flight"`
+	P50      float64 `json:"p50_ms"`
+	P99      float64 `json:"p99_ms"`
+}
+
+func (srv *controlServer) progressOf(run *benchRun) *runProgress {
+	srv.Lock()
+	prog := &runProgress{State: run.State, Current: run.Current, Finished: len(run.Results), Total: len(run.tasks)}
+	srv.Unlock()
+
+	// only tasks started by this run, stats registered by former runs are left out
+	started := prog.Finished
+	if prog.Current != "" {
+		started++
+	}
+	selected := make(map[string]bool)
+	for _, name := range run.Tasks[:started] {
+		selected[name] = true
+	}
+	for _, tm := range gMetrics.Snapshot() {
+		if !selected[tm.Task] {
+			continue
+		}
+		st := tm.Stats
+		prog.Tasks = append(prog.Tasks, &taskProgress{
+			Task:     tm.Task,
+			Tracer:   tm.Tracer,
+			Requests: st.Requests,
+			Planned:  st.Planned,
+			Spans:    st.Spans,
+			Errors:   st.Errors,
+			InFlight: st.InFlight,
+			P50:      float64(st.Latency.Quantile(0.5)) / float64(time.Millisecond),
+			P99:      float64(st.Latency.Quantile(0.99)) / float64(time.Millisecond),
+		})
+	}
+
+	return prog
+}
+
+func (srv *controlServer) streamProgress(resp http.ResponseWriter, req *http.Request, run *benchRun) {
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		writeError(resp, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+
+		return
+	}
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+
+	var (
+		ticker = time.NewTicker(time.Second)
+		send   = func() bool {
+			bts, err := json.Marshal(srv.progressOf(run))
+			if err != nil {
+				log.Println(err.Error())
+
+				return false
+			}
+			if _, err = fmt.Fprintf(resp, "data: %s\n\n", bts); err != nil {
+				return false
+			}
+			flusher.Flush()
+
+			return true
+		}
+	)
+	defer ticker.Stop()
+	for send() {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-run.done:
+			send()
+
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runServe serves control API on address until the listener fails
+func runServe(address string) error {
+	srv := newControlServer(gBenchConf, configPath)
+	srv.output = resultOutput()
+	cooldown, err := parseCooldown(gBenchConf.Cooldown)
+	if err != nil {
+		return err
+	}
+	srv.cooldown = cooldown
+	go srv.execute()
+	log.Printf("control API served on http://%s", address)
+
+	return http.ListenAndServe(address, srv)
+}
@@ -0,0 +1,138 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodapeWild/dktrace-data-benchmark/agent"
+	"github.com/CodapeWild/dktrace-data-benchmark/bench"
+)
+
+// registerNoopProtocol registers protocol noop finishing at once, once for all tests
+func registerNoopProtocol() {
+	if _, ok := bench.LookupProtocol("noop"); ok {
+		return
+	}
+	bench.RegisterProtocol(&bench.Protocol{
+		Name: "noop",
+		Start: func(ctx context.Context, task *bench.TaskConfig, endpoint string, opts ...agent.AmplifierOption) (context.CancelFunc, chan struct{}, error) {
+			finish := make(chan struct{})
+			close(finish)
+
+			return nil, finish, nil
+		},
+	})
+}
+
+// newTestControlServer serves bconf saved at path, the call returned requests it expecting
+// status and decodes response into v if not nil
+func newTestControlServer(t *testing.T, bconf *bench.BenchConfig, path string) (call func(method, url, body string, status int, v interface{})) {
+	registerNoopProtocol()
+	srv := newControlServer(bconf, path)
+	go srv.execute()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	return func(method, url, body string, status int, v interface{}) {
+		req, _ := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Fatalf("%s %s: expect status %d got %d", method, url, status, resp.StatusCode)
+		}
+		if v != nil {
+			if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+	}
+}
+
+func TestControlServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	call := newTestControlServer(t, &bench.BenchConfig{}, path)
+
+	call(http.MethodPost, "/tasks", `{"name":"noop","tracer":"unknown"}`, http.StatusBadRequest, nil)
+	call(http.MethodPost, "/tasks", `{"name":"noop","tracer":"noop"}`, http.StatusCreated, nil)
+	call(http.MethodPost, "/tasks", `{"name":"noop","tracer":"noop"}`, http.StatusConflict, nil)
+	call(http.MethodPut, "/tasks/noop", `{"tracer":"noop","send_threads":3}`, http.StatusOK, nil)
+	saved, err := bench.LoadBenchConfigFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(saved.Tasks) != 1 || saved.Tasks[0].Name != "noop" || saved.Tasks[0].SendThreads != 3 {
+		t.Fatalf("unexpected tasks persisted: %+v", saved.Tasks)
+	}
+
+	call(http.MethodPost, "/runs", `{"tasks":["missing"]}`, http.StatusNotFound, nil)
+	var run benchRun
+	call(http.MethodPost, "/runs", `{"tasks":["noop","noop"]}`, http.StatusAccepted, &run)
+	for i := 0; run.State != runFinished; i++ {
+		if i == 50 {
+			t.Fatalf("run not finished: %s", run.State)
+		}
+		time.Sleep(100 * time.Millisecond)
+		call(http.MethodGet, "/runs/"+run.ID, "", http.StatusOK, &run)
+	}
+	if len(run.Results) != 2 || run.Results[0].Name != "noop" {
+		t.Fatalf("unexpected results: %+v", run.Results)
+	}
+
+	call(http.MethodDelete, "/tasks/noop", "", http.StatusNoContent, nil)
+	call(http.MethodGet, "/tasks/noop", "", http.StatusNotFound, nil)
+}
+
+func TestControlServerSaveFailed(t *testing.T) {
+	// the directory of configuration does not exist, so saving always fails
+	path := filepath.Join(t.TempDir(), "missing", "config.json")
+	bconf := &bench.BenchConfig{
+		Tasks:    []*bench.TaskConfig{{Name: "noop", Tracer: "noop", SendThreads: 1}},
+		Matrices: []*bench.MatrixConfig{{Name: "mat", Task: &bench.TaskConfig{Tracer: "noop"}, Threads: []int{1, 2}}},
+	}
+	call := newTestControlServer(t, bconf, path)
+
+	var tasks []*bench.TaskConfig
+	call(http.MethodGet, "/tasks", "", http.StatusOK, &tasks)
+	if len(tasks) != 3 || tasks[1].Name != "mat-t1" || tasks[2].Name != "mat-t2" {
+		t.Fatalf("tasks of matrices not listed: %+v", tasks)
+	}
+	call(http.MethodGet, "/tasks/mat-t2", "", http.StatusOK, nil)
+	call(http.MethodPut, "/tasks/mat-t2", `{"tracer":"noop"}`, http.StatusConflict, nil)
+	call(http.MethodPost, "/tasks", `{"name":"mat-t1","tracer":"noop"}`, http.StatusConflict, nil)
+
+	call(http.MethodPost, "/tasks", `{"name":"other","tracer":"noop"}`, http.StatusInternalServerError, nil)
+	call(http.MethodPut, "/tasks/noop", `{"tracer":"noop","send_threads":3}`, http.StatusInternalServerError, nil)
+	call(http.MethodDelete, "/tasks/noop", "", http.StatusInternalServerError, nil)
+	var task bench.TaskConfig
+	call(http.MethodGet, "/tasks/noop", "", http.StatusOK, &task)
+	call(http.MethodGet, "/tasks/other", "", http.StatusNotFound, nil)
+	if len(bconf.Tasks) != 1 || task.SendThreads != 1 {
+		t.Fatalf("tasks changed though not saved: %+v", bconf.Tasks)
+	}
+}
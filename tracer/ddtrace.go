@@ -15,10 +15,11 @@
  *   limitations under the License.
  */
 
-package main
+package tracer
 
 import (
 	"context"
+	"strings"
 
 	ddtracer "gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
@@ -30,16 +31,18 @@ var (
 
 type DDTracerWrapper struct{}
 
+// Start starts the global tracer sending to agentAddress, a host:port or a Unix socket
+// path prefixed by unix:// as DD_TRACE_AGENT_URL accepts
 func (ddt *DDTracerWrapper) Start(agentAddress, service string) {
-	ddtracer.Start(ddtracer.WithAgentAddr(agentAddress), ddtracer.WithService(service), ddtracer.WithDebugMode(true), ddtracer.WithLogStartup(true))
+	addr := ddtracer.WithAgentAddr(agentAddress)
+	if strings.HasPrefix(agentAddress, "unix://") {
+		addr = ddtracer.WithUDS(strings.TrimPrefix(agentAddress, "unix://"))
+	}
+	ddtracer.Start(addr, ddtracer.WithService(service), ddtracer.WithDebugMode(isDebug()), ddtracer.WithLogStartup(isDebug()))
 }
 
 func (ddt *DDTracerWrapper) StartSpan(ctx context.Context) (Span, context.Context) {
-	n := ctx.Value(ctxNodeInfoKey{}).(*node)
-	operation := "unknow-operation"
-	if n != nil {
-		operation = n.action
-	}
+	operation := OperationFromContext(ctx)
 
 	span, ctx := ddtracer.StartSpanFromContext(ctx, operation)
 
@@ -15,7 +15,7 @@
  *   limitations under the License.
  */
 
-package main
+package tracer
 
 import (
 	"context"
@@ -49,11 +49,7 @@ func (jgt *JgTracerWrapper) Start(agentAddress, service string) {
 }
 
 func (jgt *JgTracerWrapper) StartSpan(ctx context.Context) (Span, context.Context) {
-	n := ctx.Value(ctxNodeInfoKey{}).(*node)
-	operation := "unknow-operation"
-	if n != nil {
-		operation = n.action
-	}
+	operation := OperationFromContext(ctx)
 
 	opctx, _ := ctx.Value(JgSpanCtxKey{}).(opentracing.SpanContext)
 	span := jgt.tracer.StartSpan(operation, opentracing.ChildOf(opctx))
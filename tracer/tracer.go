@@ -0,0 +1,66 @@
+/*
+ *   Copyright (c) 2023 CodapeWild
+ *   All rights reserved.
+
+ *   Licensed under the Apache License, Version 2.0 (the "License");
+ *   you may not use this file except in compliance with the License.
+ *   You may obtain a copy of the License at
+
+ *   http://www.apache.org/licenses/LICENSE-2.0
+
+ *   Unless required by applicable law or agreed to in writing, software
+ *   distributed under the License is distributed on an "AS IS" BASIS,
+ *   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *   See the License for the specific language governing permissions and
+ *   limitations under the License.
+ */
+
+// Package tracer wraps tracer libraries used to generate traces out of a route.
+package tracer
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type operationKey struct{}
+
+var debug int32
+
+// SetDebug switches debug logs of tracer libraries, off by default
+func SetDebug(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&debug, v)
+}
+
+func isDebug() bool {
+	return atomic.LoadInt32(&debug) == 1
+}
+
+// WithOperation returns a copy of ctx carrying the operation name of the next span
+func WithOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation)
+}
+
+// OperationFromContext returns the operation name set by WithOperation
+func OperationFromContext(ctx context.Context) string {
+	if op, ok := ctx.Value(operationKey{}).(string); ok && op != "" {
+		return op
+	}
+
+	return "unknow-operation"
+}
+
+type Tracer interface {
+	Start(agentAddress, service string)
+	StartSpan(ctx context.Context) (Span, context.Context)
+	Stop()
+}
+
+type Span interface {
+	SetTag(key string, value interface{})
+	EndSpan()
+}